@@ -0,0 +1,110 @@
+// Copyright (c) 2016 Nicolas Martyanoff <khaelin@gmail.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"strings"
+)
+
+// Analytics providers
+const (
+	AnalyticsNone      = "none"
+	AnalyticsGoogle    = "ga"
+	AnalyticsPlausible = "plausible"
+	AnalyticsMatomo    = "matomo"
+	AnalyticsCustom    = "custom" // an arbitrary HTML snippet
+)
+
+const DefaultPlausibleScriptURL = "https://plausible.io/js/script.js"
+
+// Analytics is the configuration of the analytics script included in all
+// pages, see templates/analytics.tmpl. The meaning of Id and URL depends on
+// the provider:
+//
+// - ga: Id is the tracking id.
+// - plausible: Id is the domain of the website, URL the URL of the script.
+// - matomo: Id is the site id, URL the URL of the Matomo server.
+type Analytics struct {
+	Provider string
+	Id       string
+	URL      string
+	Snippet  template.HTML
+
+	// Do not load analytics in browsers sending the Do Not Track signal
+	HonorDNT bool
+}
+
+// Check validates the configuration and sets default values.
+func (a *Analytics) Check() error {
+	switch a.Provider {
+	case AnalyticsNone:
+
+	case AnalyticsGoogle:
+		if a.Id == "" {
+			return fmt.Errorf("missing google analytics tracking id")
+		}
+
+	case AnalyticsPlausible:
+		if a.Id == "" {
+			return fmt.Errorf("missing plausible domain")
+		}
+
+		if a.URL == "" {
+			a.URL = DefaultPlausibleScriptURL
+		}
+
+	case AnalyticsMatomo:
+		if a.Id == "" {
+			return fmt.Errorf("missing matomo site id")
+		}
+
+		if a.URL == "" {
+			return fmt.Errorf("missing matomo url")
+		}
+
+		if !strings.HasSuffix(a.URL, "/") {
+			a.URL += "/"
+		}
+
+	case AnalyticsCustom:
+		if a.Snippet == "" {
+			return fmt.Errorf("missing analytics snippet")
+		}
+
+	default:
+		return fmt.Errorf("invalid analytics provider %q", a.Provider)
+	}
+
+	return nil
+}
+
+// LoadSnippet reads the HTML snippet of the custom provider from a file.
+func (a *Analytics) LoadSnippet(filePath string) error {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return fmt.Errorf("cannot read %s: %v", filePath, err)
+	}
+
+	a.Snippet = template.HTML(data)
+	return nil
+}
+
+// IsEnabled returns true if an analytics script must be included in pages.
+func (a *Analytics) IsEnabled() bool {
+	return a != nil && a.Provider != "" && a.Provider != AnalyticsNone
+}
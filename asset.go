@@ -0,0 +1,403 @@
+// Copyright (c) 2016 Nicolas Martyanoff <khaelin@gmail.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/tdewolff/minify/v2"
+	"github.com/tdewolff/minify/v2/css"
+	"github.com/tdewolff/minify/v2/html"
+	"github.com/tdewolff/minify/v2/js"
+	"github.com/tdewolff/minify/v2/json"
+)
+
+// AssetDirNames are the subdirectories of www-data containing static files.
+var AssetDirNames = []string{"js", "css", "img", "fonts"}
+
+// AssetHashLength is the number of hexadecimal characters of the content
+// hash inserted in the name of fingerprinted assets.
+const AssetHashLength = 10
+
+// AssetMediaTypes associates the extensions of the files which can be
+// minified to their media type.
+var AssetMediaTypes = map[string]string{
+	".css": "text/css",
+	".js":  "application/javascript",
+}
+
+// CompressedExtensions are the extensions of the files for which gzip and
+// brotli versions are written. Other files, e.g. images, are already
+// compressed.
+var CompressedExtensions = map[string]bool{
+	".html": true,
+	".css":  true,
+	".js":   true,
+	".json": true,
+	".xml":  true,
+	".txt":  true,
+	".svg":  true,
+	".eot":  true,
+	".ttf":  true,
+}
+
+var cssURLRe = regexp.MustCompile(`url\(\s*(['"]?)([^'")]+)(['"]?)\s*\)`)
+
+var sourceMapRe = regexp.MustCompile(`(?m)^\s*(/\*|//)# sourceMappingURL=.*$`)
+
+func NewMinifier() *minify.M {
+	m := minify.New()
+
+	m.AddFunc("text/css", css.Minify)
+	m.AddFunc("application/javascript", js.Minify)
+	m.AddFuncRegexp(regexp.MustCompile(`[/+]json$`), json.Minify)
+
+	// Pages are minified conservatively: optional tags and quotes are
+	// kept so that the output stays readable when debugging.
+	m.Add("text/html", &html.Minifier{
+		KeepDocumentTags: true,
+		KeepEndTags:      true,
+		KeepQuotes:       true,
+	})
+
+	return m
+}
+
+// CopyAssets copies the static files of www-data to the output directory.
+//
+// When minification is enabled, the minified variant of a file provided in
+// www-data (e.g. bootstrap.min.css for bootstrap.css) is used if there is
+// one, and other css and javascript files are minified. Minified variants
+// and source maps are not copied: templates always refer to the original
+// name. When fingerprinting is enabled, the hash of the content of each file
+// is inserted in its name, and references in stylesheets are rewritten
+// accordingly.
+func (g *Generator) CopyAssets() error {
+	g.assets = make(map[string]string)
+
+	optimize := g.Minify || g.Fingerprint
+
+	// Stylesheets are processed last since they refer to fonts and
+	// images.
+	var cssPaths []string
+
+	for _, subDirName := range AssetDirNames {
+		srcDirPath := path.Join(g.ShareDirPath, "www-data", subDirName)
+		files, err := ioutil.ReadDir(srcDirPath)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+
+			return fmt.Errorf("cannot list directory %s",
+				srcDirPath)
+		}
+
+		names := make(map[string]bool)
+		for _, file := range files {
+			names[file.Name()] = true
+		}
+
+		for _, file := range files {
+			name := file.Name()
+			assetPath := path.Join(subDirName, name)
+
+			if !optimize {
+				ipath := path.Join(srcDirPath, name)
+				opath := path.Join(g.OutputDirPath, assetPath)
+
+				if err := CopyFile(ipath, opath); err != nil {
+					return err
+				}
+
+				continue
+			}
+
+			if path.Ext(name) == ".map" {
+				continue
+			}
+
+			if orig := unminifiedAssetName(name); orig != "" && names[orig] {
+				continue
+			}
+
+			if path.Ext(name) == ".css" {
+				cssPaths = append(cssPaths, assetPath)
+				continue
+			}
+
+			if err := g.CopyAsset(assetPath); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, assetPath := range cssPaths {
+		if err := g.CopyAsset(assetPath); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CopyAsset copies a single static file, identified by its path relative to
+// www-data, to the output directory and records its output path.
+func (g *Generator) CopyAsset(assetPath string) error {
+	srcPath := path.Join(g.ShareDirPath, "www-data", assetPath)
+
+	data, minified, err := g.ReadAsset(srcPath)
+	if err != nil {
+		return err
+	}
+
+	ext := path.Ext(assetPath)
+
+	// Source maps are not copied
+	data = sourceMapRe.ReplaceAll(data, nil)
+
+	if ext == ".css" {
+		data = g.RewriteCSSURLs(assetPath, data)
+	}
+
+	if mediaType, found := AssetMediaTypes[ext]; found && g.Minify && !minified {
+		data, err = g.minifier.Bytes(mediaType, data)
+		if err != nil {
+			return fmt.Errorf("cannot minify %s: %v", srcPath, err)
+		}
+	}
+
+	outputPath := assetPath
+	if g.Fingerprint {
+		outputPath = FingerprintPath(assetPath, data)
+	}
+
+	filePath := path.Join(g.OutputDirPath, outputPath)
+	if err := ioutil.WriteFile(filePath, data, 0644); err != nil {
+		return fmt.Errorf("cannot write %s: %v", filePath, err)
+	}
+
+	g.assets[assetPath] = outputPath
+
+	return nil
+}
+
+// ReadAsset reads a static file, or its minified variant if minification is
+// enabled and if there is one. The boolean indicates whether the variant was
+// used.
+func (g *Generator) ReadAsset(filePath string) ([]byte, bool, error) {
+	if g.Minify {
+		ext := path.Ext(filePath)
+		minPath := strings.TrimSuffix(filePath, ext) + ".min" + ext
+
+		data, err := ioutil.ReadFile(minPath)
+		if err == nil {
+			return data, true, nil
+		} else if !os.IsNotExist(err) {
+			return nil, false, fmt.Errorf("cannot read %s: %v",
+				minPath, err)
+		}
+	}
+
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, false, fmt.Errorf("cannot read %s: %v", filePath, err)
+	}
+
+	return data, false, nil
+}
+
+// RewriteCSSURLs replaces the relative urls of a stylesheet referring to
+// assets by the urls of their fingerprinted version. Query strings and
+// fragments, which old browsers rely on to load fonts, are preserved.
+func (g *Generator) RewriteCSSURLs(assetPath string, data []byte) []byte {
+	dirPath := path.Dir(assetPath)
+
+	return cssURLRe.ReplaceAllFunc(data, func(match []byte) []byte {
+		groups := cssURLRe.FindSubmatch(match)
+		ref := string(groups[2])
+
+		if strings.Contains(ref, ":") || strings.HasPrefix(ref, "/") {
+			return match
+		}
+
+		refPath, suffix := ref, ""
+		if idx := strings.IndexAny(ref, "?#"); idx >= 0 {
+			refPath, suffix = ref[:idx], ref[idx:]
+		}
+
+		outputPath, found := g.assets[path.Join(dirPath, refPath)]
+		if !found {
+			return match
+		}
+
+		newRef := path.Join(path.Dir(refPath), path.Base(outputPath))
+
+		return []byte(fmt.Sprintf("url(%s%s%s%s)",
+			groups[1], newRef, suffix, groups[3]))
+	})
+}
+
+// AssetPath returns the path of the output file of a static file, which is
+// different from its original path if it was fingerprinted. Other paths are
+// returned unchanged.
+func (g *Generator) AssetPath(assetPath string) string {
+	if outputPath, found := g.assets[assetPath]; found {
+		return outputPath
+	}
+
+	return assetPath
+}
+
+// FingerprintPath inserts the hash of the content of a file in its path,
+// e.g. "css/main.css" becomes "css/main.0123456789.css".
+func FingerprintPath(filePath string, data []byte) string {
+	hash := sha256.Sum256(data)
+	hexHash := hex.EncodeToString(hash[:])[:AssetHashLength]
+
+	ext := path.Ext(filePath)
+
+	return strings.TrimSuffix(filePath, ext) + "." + hexHash + ext
+}
+
+// unminifiedAssetName returns the name of the original version of a
+// minified file, e.g. "bootstrap.css" for "bootstrap.min.css", or an empty
+// string if the file is not a minified variant.
+func unminifiedAssetName(name string) string {
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+
+	if path.Ext(base) != ".min" {
+		return ""
+	}
+
+	return strings.TrimSuffix(base, ".min") + ext
+}
+
+// CompressFiles writes a gzip and a brotli version of each compressible file
+// of a directory and its subdirectories, so that web servers can serve them
+// directly (e.g. with the gzip_static and brotli_static nginx directives).
+// Compressed versions which would not be smaller than the original file are
+// not written.
+func CompressFiles(dirPath string) error {
+	var links []string
+
+	err := filepath.Walk(dirPath, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.Mode()&os.ModeSymlink != 0 {
+			links = append(links, filePath)
+			return nil
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		if !CompressedExtensions[path.Ext(filePath)] {
+			return nil
+		}
+
+		return CompressFile(filePath)
+	})
+	if err != nil {
+		return fmt.Errorf("cannot compress files in %s: %v", dirPath, err)
+	}
+
+	// Symbolic links (e.g. index.html) must point to the compressed
+	// versions of their target.
+	for _, linkPath := range links {
+		target, err := os.Readlink(linkPath)
+		if err != nil {
+			return fmt.Errorf("cannot read link %s: %v", linkPath, err)
+		}
+
+		for _, ext := range []string{".gz", ".br"} {
+			targetPath := target
+			if !filepath.IsAbs(targetPath) {
+				targetPath = filepath.Join(filepath.Dir(linkPath),
+					target)
+			}
+
+			if _, err := os.Stat(targetPath + ext); err != nil {
+				continue
+			}
+
+			if err := os.Symlink(target+ext, linkPath+ext); err != nil {
+				return fmt.Errorf("cannot symlink %s to %s: %v",
+					linkPath+ext, target+ext, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func CompressFile(filePath string) error {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return fmt.Errorf("cannot read %s: %v", filePath, err)
+	}
+
+	compressors := []struct {
+		Ext       string
+		NewWriter func(io.Writer) io.WriteCloser
+	}{
+		{".gz", func(w io.Writer) io.WriteCloser {
+			gw, _ := gzip.NewWriterLevel(w, gzip.BestCompression)
+			return gw
+		}},
+		{".br", func(w io.Writer) io.WriteCloser {
+			return brotli.NewWriterLevel(w, brotli.BestCompression)
+		}},
+	}
+
+	for _, c := range compressors {
+		var buf bytes.Buffer
+
+		w := c.NewWriter(&buf)
+		if _, err := w.Write(data); err != nil {
+			return fmt.Errorf("cannot compress %s: %v", filePath, err)
+		}
+		if err := w.Close(); err != nil {
+			return fmt.Errorf("cannot compress %s: %v", filePath, err)
+		}
+
+		if buf.Len() >= len(data) {
+			continue
+		}
+
+		outputPath := filePath + c.Ext
+		if err := ioutil.WriteFile(outputPath, buf.Bytes(), 0644); err != nil {
+			return fmt.Errorf("cannot write %s: %v", outputPath, err)
+		}
+	}
+
+	return nil
+}
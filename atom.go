@@ -0,0 +1,98 @@
+// Copyright (c) 2016 Nicolas Martyanoff <khaelin@gmail.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strconv"
+
+	"github.com/gorilla/feeds"
+)
+
+// Atom feeds expose metadata computed by Planet Golang in entries using a
+// dedicated namespace.
+const AtomNamespace = "http://planetgolang.com/xmlns/atom"
+
+type AtomFeed struct {
+	*feeds.AtomFeed
+
+	XmlnsPlanet string       `xml:"xmlns:planet,attr"`
+	Entries     []*AtomEntry `xml:"entry"`
+}
+
+type AtomEntry struct {
+	*feeds.AtomEntry
+
+	WordCount      int `xml:"planet:wordCount"`
+	ReadingMinutes int `xml:"planet:readingTime"`
+}
+
+// WriteAtomFeed writes a feed in the Atom format. Posts must be the posts the
+// items of the feed were generated from, in the same order.
+func (g *Generator) WriteAtomFeed(filePath string, feed *feeds.Feed, posts PostList) error {
+	atomFeed := (&feeds.Atom{Feed: feed}).AtomFeed()
+
+	data := &AtomFeed{
+		AtomFeed:    atomFeed,
+		XmlnsPlanet: AtomNamespace,
+		Entries:     make([]*AtomEntry, len(atomFeed.Entries)),
+	}
+
+	for i, entry := range atomFeed.Entries {
+		post := posts[i]
+
+		// Unlike RSS items, Atom entries can have several enclosures;
+		// the first one was added by the feeds package.
+		for j := 1; j < len(post.Enclosures); j++ {
+			e := post.Enclosures[j]
+
+			link := feeds.AtomLink{
+				Href: e.URL,
+				Rel:  "enclosure",
+				Type: e.Type,
+			}
+
+			if e.Length > 0 {
+				link.Length = strconv.FormatInt(e.Length, 10)
+			}
+
+			entry.Links = append(entry.Links, link)
+		}
+
+		data.Entries[i] = &AtomEntry{
+			AtomEntry:      entry,
+			WordCount:      post.WordCount,
+			ReadingMinutes: post.ReadingMinutes(),
+		}
+	}
+
+	content, err := xml.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return fmt.Errorf("cannot encode atom feed: %v", err)
+	}
+
+	content = append([]byte(xml.Header), content...)
+
+	// Write it
+	filePath = path.Join(g.OutputDirPath, filePath)
+	if err := ioutil.WriteFile(filePath, content, 0644); err != nil {
+		return fmt.Errorf("cannot write %s: %v", filePath, err)
+	}
+
+	return nil
+}
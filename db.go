@@ -18,6 +18,7 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -43,6 +44,26 @@ func (db *DB) Open(dbPath string) error {
 		return fmt.Errorf("cannot enable foreign keys: %v", err)
 	}
 
+	return db.CheckFTS5()
+}
+
+// CheckFTS5 makes sure that SQLite supports FTS5, which is used for
+// full-text search. The go-sqlite3 driver only enables it when the program
+// is built with the sqlite_fts5 tag (see GNUmakefile).
+func (db *DB) CheckFTS5() error {
+	var enabled bool
+
+	row := db.conn.QueryRow(
+		`SELECT sqlite_compileoption_used('ENABLE_FTS5')`)
+	if err := row.Scan(&enabled); err != nil {
+		return fmt.Errorf("cannot check sqlite compile options: %v", err)
+	}
+
+	if !enabled {
+		return fmt.Errorf("sqlite was built without fts5 support; " +
+			"build planetgolang with \"go build -tags sqlite_fts5\"")
+	}
+
 	return nil
 }
 
@@ -70,3 +91,84 @@ func (db *DB) WithTx(fn func(*sql.Tx) error) error {
 
 	return nil
 }
+
+// WithSavepoint calls a function in a savepoint of a transaction. If the
+// function fails, changes made since the beginning of the savepoint are
+// rolled back but the transaction is still usable.
+func WithSavepoint(tx *sql.Tx, name string, fn func() error) error {
+	if _, err := tx.Exec("SAVEPOINT " + name); err != nil {
+		return fmt.Errorf("cannot create savepoint: %v", err)
+	}
+
+	if err := fn(); err != nil {
+		if _, sperr := tx.Exec("ROLLBACK TO " + name); sperr != nil {
+			log.Panicf("cannot rollback to savepoint: %v", sperr)
+		}
+
+		if _, sperr := tx.Exec("RELEASE " + name); sperr != nil {
+			log.Panicf("cannot release savepoint: %v", sperr)
+		}
+
+		return err
+	}
+
+	if _, err := tx.Exec("RELEASE " + name); err != nil {
+		return fmt.Errorf("cannot release savepoint: %v", err)
+	}
+
+	return nil
+}
+
+// Size returns the size of the database in bytes, free pages excluded.
+func (db *DB) Size() (int64, error) {
+	var pageCount, freeCount, pageSize int64
+
+	pragmas := []struct {
+		name  string
+		value *int64
+	}{
+		{"page_count", &pageCount},
+		{"freelist_count", &freeCount},
+		{"page_size", &pageSize},
+	}
+
+	for _, pragma := range pragmas {
+		row := db.conn.QueryRow("PRAGMA " + pragma.name)
+		if err := row.Scan(pragma.value); err != nil {
+			return -1, fmt.Errorf("cannot read %s: %v",
+				pragma.name, err)
+		}
+	}
+
+	return (pageCount - freeCount) * pageSize, nil
+}
+
+// Vacuum rebuilds the database file to reclaim unused space. It cannot be
+// called in a transaction.
+func (db *DB) Vacuum() error {
+	if _, err := db.conn.Exec("VACUUM"); err != nil {
+		return fmt.Errorf("cannot vacuum database: %v", err)
+	}
+
+	return nil
+}
+
+// Timestamp converts a time to the unix timestamp stored in the database.
+// The zero time is stored as 0.
+func Timestamp(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+
+	return t.UTC().Unix()
+}
+
+// TimestampTime converts a unix timestamp read from the database to a time.
+// A timestamp of 0 is read as the zero time.
+func TimestampTime(timestamp int64) time.Time {
+	if timestamp == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(timestamp, 0).UTC()
+}
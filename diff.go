@@ -0,0 +1,132 @@
+// Copyright (c) 2016 Nicolas Martyanoff <khaelin@gmail.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+)
+
+type DiffOp int
+
+const (
+	DiffEqual DiffOp = iota
+	DiffDelete
+	DiffInsert
+)
+
+type DiffLine struct {
+	Op   DiffOp
+	Text string
+}
+
+// DiffLines computes the differences between two lists of lines using the
+// longest common subsequence of both lists.
+func DiffLines(a, b []string) []DiffLine {
+	// lcs[i][j] is the length of the longest common subsequence of a[i:]
+	// and b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var lines []DiffLine
+
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			lines = append(lines, DiffLine{DiffEqual, a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			lines = append(lines, DiffLine{DiffDelete, a[i]})
+			i++
+		default:
+			lines = append(lines, DiffLine{DiffInsert, b[j]})
+			j++
+		}
+	}
+
+	for ; i < len(a); i++ {
+		lines = append(lines, DiffLine{DiffDelete, a[i]})
+	}
+
+	for ; j < len(b); j++ {
+		lines = append(lines, DiffLine{DiffInsert, b[j]})
+	}
+
+	return lines
+}
+
+// FormatDiff formats differences with one line per entry prefixed by "-",
+// "+" or " ", keeping at most context unchanged lines around each change.
+func FormatDiff(lines []DiffLine, context int) string {
+	var buf bytes.Buffer
+
+	// Find which unchanged lines are close enough to a change to be
+	// displayed.
+	visible := make([]bool, len(lines))
+	for i, line := range lines {
+		if line.Op == DiffEqual {
+			continue
+		}
+
+		for j := i - context; j <= i+context; j++ {
+			if j >= 0 && j < len(lines) {
+				visible[j] = true
+			}
+		}
+	}
+
+	skipped := false
+	for i, line := range lines {
+		if !visible[i] {
+			skipped = true
+			continue
+		}
+
+		if skipped {
+			buf.WriteString("...\n")
+			skipped = false
+		}
+
+		var prefix string
+		switch line.Op {
+		case DiffEqual:
+			prefix = " "
+		case DiffDelete:
+			prefix = "-"
+		case DiffInsert:
+			prefix = "+"
+		}
+
+		fmt.Fprintf(&buf, "%s %s\n", prefix, line.Text)
+	}
+
+	return buf.String()
+}
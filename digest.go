@@ -0,0 +1,327 @@
+// Copyright (c) 2016 Nicolas Martyanoff <khaelin@gmail.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	htmltemplate "html/template"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"path"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// DigestPeriod is the period covered by the first digest, when there is no
+// previous digest to start from.
+const DigestPeriod = 7 * 24 * time.Hour
+
+// DigestExcerptLength is the maximum length of the excerpts of posts in
+// digests.
+const DigestExcerptLength = 300
+
+type Digest struct {
+	Since time.Time
+	Until time.Time
+
+	Posts PostList
+	Feeds map[int64]*Feed
+}
+
+type DigestData struct {
+	Title   string
+	SiteURL string
+
+	Since time.Time
+	Until time.Time
+
+	Posts []DigestPostData
+}
+
+type DigestPostData struct {
+	Post   *Post
+	Feed   *Feed
+	Author string
+	URL    string // the page of the post on the website
+
+	// Excerpts are empty for feeds whose posts are displayed as titles
+	Excerpt     htmltemplate.HTML
+	TextExcerpt string
+}
+
+// DigestMessage is an email containing a digest, both as plain text and as
+// HTML.
+type DigestMessage struct {
+	From    *mail.Address
+	To      []*mail.Address
+	Subject string
+	Date    time.Time
+
+	Text []byte
+	HTML []byte
+}
+
+// LastDigestDate returns the end of the period covered by the last digest,
+// or the zero time if no digest was recorded.
+func LastDigestDate(tx *sql.Tx) (time.Time, error) {
+	var timestamp int64
+
+	row := tx.QueryRow(`SELECT coalesce(max(date), 0) FROM digests`)
+	if err := row.Scan(&timestamp); err != nil {
+		return time.Time{}, fmt.Errorf("cannot load last digest "+
+			"date: %v", err)
+	}
+
+	return TimestampTime(timestamp), nil
+}
+
+func (d *Digest) Record(tx *sql.Tx) error {
+	_, err := tx.Exec(`INSERT INTO digests (date, nb_posts) VALUES (?, ?)`,
+		Timestamp(d.Until), len(d.Posts))
+	if err != nil {
+		return fmt.Errorf("cannot insert digest: %v", err)
+	}
+
+	return nil
+}
+
+// Load loads the posts of enabled feeds first seen during the period of the
+// digest. Using the date posts were first seen instead of their publication
+// date makes sure that posts published with a date in the past, e.g.
+// because their feed was unavailable for some time, are not missed.
+func (d *Digest) Load(tx *sql.Tx) error {
+	var fl FeedList
+	if err := fl.LoadEnabled(tx); err != nil {
+		return err
+	}
+
+	d.Feeds = make(map[int64]*Feed)
+	for _, f := range fl {
+		d.Feeds[f.Id] = f
+	}
+
+	d.Posts = nil
+
+	return d.Posts.Load(tx,
+		`SELECT `+postColumns+`
+		   FROM posts AS p
+		   INNER JOIN feeds AS f ON f.id = p.feed
+		   WHERE f.enabled = 1 AND p.enabled = 1
+		     AND p.first_seen > ? AND p.first_seen <= ?
+		   ORDER BY `+postOrderDate+` DESC`,
+		Timestamp(d.Since), Timestamp(d.Until))
+}
+
+func (d *Digest) Data(title, baseURL string) *DigestData {
+	data := &DigestData{
+		Title:   title,
+		SiteURL: JoinURL(baseURL, ""),
+
+		Since: d.Since,
+		Until: d.Until,
+
+		Posts: make([]DigestPostData, len(d.Posts)),
+	}
+
+	for i, post := range d.Posts {
+		feed := d.Feeds[post.FeedId]
+
+		postData := DigestPostData{
+			Post:   post,
+			Feed:   feed,
+			Author: HTMLText(post.AuthorName(feed)),
+			URL:    JoinURL(baseURL, post.PagePath()),
+		}
+
+		// Full contents would make digests far too long
+		display := RestrictDisplayMode(DisplayExcerpt, feed.Display)
+
+		if display != DisplayTitle {
+			excerpt, _ := Excerpt(post.Content, DigestExcerptLength)
+			postData.Excerpt = htmltemplate.HTML(excerpt)
+
+			postData.TextExcerpt = TruncateText(
+				HTMLProseText(post.Content), DigestExcerptLength)
+		}
+
+		data.Posts[i] = postData
+	}
+
+	return data
+}
+
+// Render renders the plain text and HTML versions of a digest using the
+// digest-text and digest-html templates.
+func (data *DigestData) Render(shareDirPath string) ([]byte, []byte, error) {
+	tplDirPath := path.Join(shareDirPath, "templates")
+
+	textTpl, err := template.ParseFiles(
+		path.Join(tplDirPath, "digest-text.tmpl"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot load templates: %v", err)
+	}
+
+	htmlTpl, err := htmltemplate.ParseFiles(
+		path.Join(tplDirPath, "digest-html.tmpl"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot load templates: %v", err)
+	}
+
+	var text, html bytes.Buffer
+
+	if err := textTpl.ExecuteTemplate(&text, "digest-text", data); err != nil {
+		return nil, nil, fmt.Errorf("cannot execute template "+
+			"digest-text: %v", err)
+	}
+
+	if err := htmlTpl.ExecuteTemplate(&html, "digest-html", data); err != nil {
+		return nil, nil, fmt.Errorf("cannot execute template "+
+			"digest-html: %v", err)
+	}
+
+	return text.Bytes(), html.Bytes(), nil
+}
+
+// Encode returns the message in the MIME format, as a multipart/alternative
+// entity containing the plain text and HTML versions of the digest. Lines
+// end with CRLF as required by RFC 5322.
+func (m *DigestMessage) Encode() ([]byte, error) {
+	var buf bytes.Buffer
+
+	to := make([]string, len(m.To))
+	for i, addr := range m.To {
+		to[i] = addr.String()
+	}
+
+	messageId, err := m.MessageId()
+	if err != nil {
+		return nil, err
+	}
+
+	mw := multipart.NewWriter(&buf)
+
+	header := []struct {
+		Name  string
+		Value string
+	}{
+		{"From", m.From.String()},
+		{"To", strings.Join(to, ", ")},
+		{"Subject", mime.QEncoding.Encode("utf-8", m.Subject)},
+		{"Date", m.Date.Format(time.RFC1123Z)},
+		{"Message-ID", messageId},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", mime.FormatMediaType("multipart/alternative",
+			map[string]string{"boundary": mw.Boundary()})},
+	}
+
+	for _, field := range header {
+		fmt.Fprintf(&buf, "%s: %s\r\n", field.Name, field.Value)
+	}
+	buf.WriteString("\r\n")
+
+	// Clients display the last part they support, so the HTML version
+	// comes last.
+	parts := []struct {
+		ContentType string
+		Content     []byte
+	}{
+		{"text/plain; charset=utf-8", m.Text},
+		{"text/html; charset=utf-8", m.HTML},
+	}
+
+	for _, part := range parts {
+		pw, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.ContentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("cannot create mime part: %v", err)
+		}
+
+		qw := quotedprintable.NewWriter(pw)
+		if _, err := qw.Write(part.Content); err != nil {
+			return nil, fmt.Errorf("cannot encode mime part: %v", err)
+		}
+		if err := qw.Close(); err != nil {
+			return nil, fmt.Errorf("cannot encode mime part: %v", err)
+		}
+	}
+
+	if err := mw.Close(); err != nil {
+		return nil, fmt.Errorf("cannot encode message: %v", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// MessageId returns a new unique message id using the domain of the sender.
+func (m *DigestMessage) MessageId() (string, error) {
+	randomData := make([]byte, 16)
+	if _, err := rand.Read(randomData); err != nil {
+		return "", fmt.Errorf("cannot generate random data: %v", err)
+	}
+
+	domain := "localhost"
+	if i := strings.LastIndexByte(m.From.Address, '@'); i >= 0 {
+		domain = m.From.Address[i+1:]
+	}
+
+	return fmt.Sprintf("<%d.%s@%s>", m.Date.Unix(),
+		hex.EncodeToString(randomData), domain), nil
+}
+
+// Send sends the message using an SMTP server identified by its address
+// ("host:port"). The connection is upgraded with STARTTLS if the server
+// supports it. If user is not empty, the client authenticates with the PLAIN
+// mechanism, which the net/smtp package only allows on encrypted
+// connections or with a server running on localhost.
+func (m *DigestMessage) Send(server, user, password string) error {
+	data, err := m.Encode()
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if user != "" {
+		host, _, err := net.SplitHostPort(server)
+		if err != nil {
+			return fmt.Errorf("invalid smtp server address %q: %v",
+				server, err)
+		}
+
+		auth = smtp.PlainAuth("", user, password, host)
+	}
+
+	to := make([]string, len(m.To))
+	for i, addr := range m.To {
+		to[i] = addr.Address
+	}
+
+	if err := smtp.SendMail(server, auth, m.From.Address, to, data); err != nil {
+		return fmt.Errorf("cannot send message via %s: %v", server, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,137 @@
+// Copyright (c) 2016 Nicolas Martyanoff <khaelin@gmail.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDigestMessageEncode(t *testing.T) {
+	msg := &DigestMessage{
+		From: &mail.Address{Name: "Planet Golang",
+			Address: "digest@planetgolang.com"},
+		To: []*mail.Address{
+			{Address: "a@example.com"},
+			{Name: "Bob", Address: "b@example.com"},
+		},
+		Subject: "Digest — October 19",
+		Date:    time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC),
+
+		Text: []byte("Posts of the week: " + strings.Repeat("é", 100)),
+		HTML: []byte(`<p class="intro">Posts of the week</p>`),
+	}
+
+	data, err := msg.Encode()
+	if err != nil {
+		t.Fatalf("cannot encode message: %v", err)
+	}
+
+	// Lines
+	for i, line := range bytes.SplitAfter(data, []byte("\n")) {
+		if len(line) > 0 && !bytes.HasSuffix(line, []byte("\r\n")) {
+			t.Errorf("line %d does not end with CRLF: %q", i+1, line)
+		}
+		if len(line) > 1000 {
+			// RFC 5322 limits lines to 998 characters without CRLF
+			t.Errorf("line %d is too long: %q", i+1, line)
+		}
+	}
+
+	// Header
+	m, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("cannot parse message: %v", err)
+	}
+
+	decoder := new(mime.WordDecoder)
+	subject, err := decoder.DecodeHeader(m.Header.Get("Subject"))
+	if err != nil || subject != msg.Subject {
+		t.Errorf("invalid subject %q", m.Header.Get("Subject"))
+	}
+
+	if from, err := m.Header.AddressList("From"); err != nil ||
+		len(from) != 1 || from[0].Address != msg.From.Address {
+		t.Errorf("invalid sender %q", m.Header.Get("From"))
+	}
+
+	if to, err := m.Header.AddressList("To"); err != nil || len(to) != 2 ||
+		to[0].Address != "a@example.com" ||
+		to[1].Address != "b@example.com" {
+		t.Errorf("invalid recipients %q", m.Header.Get("To"))
+	}
+
+	if date, err := m.Header.Date(); err != nil || !date.Equal(msg.Date) {
+		t.Errorf("invalid date %q", m.Header.Get("Date"))
+	}
+
+	messageId := m.Header.Get("Message-Id")
+	if !strings.HasPrefix(messageId, "<") ||
+		!strings.HasSuffix(messageId, "@planetgolang.com>") {
+		t.Errorf("invalid message id %q", messageId)
+	}
+
+	// Parts
+	mediaType, params, err := mime.ParseMediaType(
+		m.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("invalid content type %q", m.Header.Get("Content-Type"))
+	}
+
+	mr := multipart.NewReader(m.Body, params["boundary"])
+
+	expectedParts := []struct {
+		contentType string
+		content     []byte
+	}{
+		{"text/plain; charset=utf-8", msg.Text},
+		{"text/html; charset=utf-8", msg.HTML},
+	}
+
+	for i, expected := range expectedParts {
+		part, err := mr.NextPart()
+		if err != nil {
+			t.Fatalf("cannot read part %d: %v", i+1, err)
+		}
+
+		contentType := part.Header.Get("Content-Type")
+		if contentType != expected.contentType {
+			t.Errorf("part %d: invalid content type %q", i+1,
+				contentType)
+		}
+
+		// Quoted-printable content is decoded by the reader
+		content, err := ioutil.ReadAll(part)
+		if err != nil {
+			t.Fatalf("cannot read part %d: %v", i+1, err)
+		}
+
+		if !bytes.Equal(content, expected.content) {
+			t.Errorf("part %d: got %q, expected %q", i+1, content,
+				expected.content)
+		}
+	}
+
+	if _, err := mr.NextPart(); err != io.EOF {
+		t.Errorf("unexpected part after the html part: %v", err)
+	}
+}
@@ -0,0 +1,203 @@
+// Copyright (c) 2016 Nicolas Martyanoff <khaelin@gmail.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	// Posts of different feeds whose titles are at least this similar
+	// (see TitleSimilarity) and which were published within
+	// DuplicatePostMaxDelay of each other are considered identical if
+	// they are hosted on the same website or if their contents are at
+	// least DuplicateContentMinSimilarity similar. Titles alone are not
+	// enough: generic titles such as "Weekly links" are common.
+	DuplicateTitleMinSimilarity   = 0.8
+	DuplicateContentMinSimilarity = 0.6
+	DuplicatePostMaxDelay         = 7 * 24 * time.Hour
+)
+
+// FindDuplicatePost looks for an existing post of another feed identical to
+// a new post: a post with the same canonical URL, or a post with a very
+// similar title published around the same date, and either hosted on the
+// same website or with a similar content. It returns nil if there is no such
+// post.
+func FindDuplicatePost(tx *sql.Tx, p *Post) (*Post, error) {
+	var posts PostList
+
+	err := posts.Load(tx,
+		`SELECT `+postColumns+`
+		   FROM posts AS p
+		   WHERE p.feed <> ? AND (p.canonical_url = ? OR p.url = ?)
+		   LIMIT 1`, p.FeedId, CanonicalURL(p.URL), p.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(posts) > 0 {
+		return posts[0], nil
+	}
+
+	date := p.Date
+	if date.Before(minPostDate) {
+		// We cannot compare titles without a reliable date
+		return nil, nil
+	}
+
+	err = posts.Load(tx,
+		`SELECT `+postColumns+`
+		   FROM posts AS p
+		   WHERE p.feed <> ? AND `+postOrderDate+` BETWEEN ? AND ?`,
+		p.FeedId,
+		Timestamp(date.Add(-DuplicatePostMaxDelay)),
+		Timestamp(date.Add(DuplicatePostMaxDelay)))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, post := range posts {
+		similarity := TitleSimilarity(p.Title, post.Title)
+		if similarity < DuplicateTitleMinSimilarity {
+			continue
+		}
+
+		if URLHost(p.URL) != "" && URLHost(p.URL) == URLHost(post.URL) {
+			return post, nil
+		}
+
+		similarity = ContentSimilarity(p.Content, post.Content)
+		if similarity >= DuplicateContentMinSimilarity {
+			return post, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// TitleWords returns the set of the words of a title, or of any text, in
+// lower case.
+func TitleWords(title string) map[string]bool {
+	fields := strings.FieldsFunc(strings.ToLower(title), func(c rune) bool {
+		return !unicode.IsLetter(c) && !unicode.IsNumber(c)
+	})
+
+	words := make(map[string]bool)
+	for _, field := range fields {
+		words[field] = true
+	}
+
+	return words
+}
+
+// TitleSimilarity returns the Jaccard index of the sets of words of two
+// titles, between 0 (no common word) and 1 (same words).
+func TitleSimilarity(title1, title2 string) float64 {
+	return wordSetSimilarity(TitleWords(title1), TitleWords(title2))
+}
+
+// ContentSimilarity returns the Jaccard index of the sets of words of the
+// text of two HTML contents.
+func ContentSimilarity(content1, content2 string) float64 {
+	return wordSetSimilarity(TitleWords(HTMLText(content1)),
+		TitleWords(HTMLText(content2)))
+}
+
+func wordSetSimilarity(words1, words2 map[string]bool) float64 {
+	if len(words1) == 0 || len(words2) == 0 {
+		return 0
+	}
+
+	common := 0
+	for word := range words2 {
+		if words1[word] {
+			common++
+		}
+	}
+
+	return float64(common) / float64(len(words1)+len(words2)-common)
+}
+
+// RecordDuplicatePost records a post as a duplicate of an existing post, so
+// that it is ignored as long as the original post exists.
+func RecordDuplicatePost(tx *sql.Tx, p, original *Post) error {
+	_, err := tx.Exec(
+		`INSERT OR REPLACE INTO duplicate_posts (feed, key, original)
+		   VALUES (?, ?, ?)`, p.FeedId, p.Key(), original.Id)
+	if err != nil {
+		return fmt.Errorf("cannot record duplicate post: %v", err)
+	}
+
+	return nil
+}
+
+// LoadDuplicatePostKeys returns the set of the keys of the posts of a feed
+// recorded as duplicates of existing posts.
+func LoadDuplicatePostKeys(tx *sql.Tx, feedId int64) (map[string]bool, error) {
+	rows, err := tx.Query(
+		`SELECT key FROM duplicate_posts WHERE feed = ?`, feedId)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load duplicate posts: %v", err)
+	}
+	defer rows.Close()
+
+	keys := make(map[string]bool)
+
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, fmt.Errorf("invalid duplicate post: %v", err)
+		}
+
+		keys[key] = true
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("cannot load duplicate posts: %v", err)
+	}
+
+	return keys, nil
+}
+
+// DeleteDuplicates forgets the posts recorded as duplicates of the post, so
+// that they are imported again once the post is deleted.
+func (p *Post) DeleteDuplicates(tx *sql.Tx) error {
+	_, err := tx.Exec(`DELETE FROM duplicate_posts WHERE original = ?`, p.Id)
+	if err != nil {
+		return fmt.Errorf("cannot delete duplicate posts: %v", err)
+	}
+
+	return nil
+}
+
+// UpdateCanonicalURLs computes the canonical URL of all posts.
+func UpdateCanonicalURLs(tx *sql.Tx) error {
+	var posts PostList
+	if err := posts.LoadAll(tx); err != nil {
+		return err
+	}
+
+	for _, p := range posts {
+		if err := p.Update(tx); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
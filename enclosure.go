@@ -0,0 +1,296 @@
+// Copyright (c) 2016 Nicolas Martyanoff <khaelin@gmail.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"mime"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+	ext "github.com/mmcdole/gofeed/extensions"
+)
+
+// MaxEnclosureDuration is the longest duration accepted for enclosures;
+// longer durations are considered invalid.
+const MaxEnclosureDuration = 7 * 24 * time.Hour
+
+// An Enclosure is a media file attached to a post, for example the episode
+// of a podcast or the video of a talk.
+type Enclosure struct {
+	URL      string
+	Type     string        // MIME type, optional
+	Length   int64         // bytes, optional
+	Duration time.Duration // optional
+}
+
+type EnclosureList []*Enclosure
+
+// ReadEnclosures returns the enclosures of a feed item, from both enclosure
+// and media:content elements. Images are ignored since they are usually
+// thumbnails.
+func ReadEnclosures(item *gofeed.Item) EnclosureList {
+	var el EnclosureList
+
+	seen := make(map[string]bool)
+
+	add := func(e *Enclosure) {
+		if e.URL == "" || seen[e.URL] {
+			return
+		}
+
+		if e.Type == "" {
+			e.Type = guessEnclosureType(e.URL)
+		}
+
+		if strings.HasPrefix(e.Type, "image/") {
+			return
+		}
+
+		seen[e.URL] = true
+		el = append(el, e)
+	}
+
+	for _, ge := range item.Enclosures {
+		length, _ := strconv.ParseInt(ge.Length, 10, 64)
+
+		add(&Enclosure{
+			URL:    strings.TrimSpace(ge.URL),
+			Type:   strings.TrimSpace(ge.Type),
+			Length: length,
+		})
+	}
+
+	for _, me := range mediaContentExtensions(item.Extensions) {
+		if me.Attrs["medium"] == "image" {
+			continue
+		}
+
+		length, _ := strconv.ParseInt(me.Attrs["fileSize"], 10, 64)
+
+		add(&Enclosure{
+			URL:      strings.TrimSpace(me.Attrs["url"]),
+			Type:     strings.TrimSpace(me.Attrs["type"]),
+			Length:   length,
+			Duration: ParseEnclosureDuration(me.Attrs["duration"]),
+		})
+	}
+
+	// The iTunes duration applies to the episode, i.e. to the first
+	// enclosure.
+	if item.ITunesExt != nil && len(el) > 0 && el[0].Duration == 0 {
+		el[0].Duration = ParseEnclosureDuration(item.ITunesExt.Duration)
+	}
+
+	return el
+}
+
+func mediaContentExtensions(exts ext.Extensions) []ext.Extension {
+	media, found := exts["media"]
+	if !found {
+		return nil
+	}
+
+	contents := media["content"]
+
+	for _, group := range media["group"] {
+		contents = append(contents, group.Children["content"]...)
+	}
+
+	return contents
+}
+
+func guessEnclosureType(enclosureURL string) string {
+	u, err := url.Parse(enclosureURL)
+	if err != nil {
+		return ""
+	}
+
+	mimeType := mime.TypeByExtension(path.Ext(u.Path))
+	if mimeType == "" {
+		return ""
+	}
+
+	mediaType, _, err := mime.ParseMediaType(mimeType)
+	if err != nil {
+		return ""
+	}
+
+	return mediaType
+}
+
+// ParseEnclosureDuration parses a duration either as a number of seconds or
+// in the "[[HH:]MM:]SS" format used by iTunes. It returns 0 if the duration
+// is invalid or longer than MaxEnclosureDuration.
+func ParseEnclosureDuration(s string) time.Duration {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0
+	}
+
+	var seconds float64
+
+	for _, part := range strings.Split(s, ":") {
+		n, err := strconv.ParseFloat(part, 64)
+		if err != nil || n < 0 || math.IsInf(n, 0) || math.IsNaN(n) {
+			return 0
+		}
+
+		seconds = seconds*60 + n
+		if seconds > MaxEnclosureDuration.Seconds() {
+			return 0
+		}
+	}
+
+	return time.Duration(seconds * float64(time.Second)).Round(time.Second)
+}
+
+// Kind returns "audio" or "video" for media files which can be played in a
+// browser, or an empty string for other files.
+func (e *Enclosure) Kind() string {
+	switch {
+	case strings.HasPrefix(e.Type, "audio/"):
+		return "audio"
+	case strings.HasPrefix(e.Type, "video/"):
+		return "video"
+	}
+
+	return ""
+}
+
+// FileName returns the last element of the path of the URL of the enclosure.
+func (e *Enclosure) FileName() string {
+	u, err := url.Parse(e.URL)
+	if err != nil || path.Base(u.Path) == "/" || path.Base(u.Path) == "." {
+		return e.URL
+	}
+
+	return path.Base(u.Path)
+}
+
+// FormattedDuration returns the duration in the "[H:]MM:SS" format, or an
+// empty string if it is unknown.
+func (e *Enclosure) FormattedDuration() string {
+	if e.Duration <= 0 {
+		return ""
+	}
+
+	seconds := int64(e.Duration / time.Second)
+	h, m, s := seconds/3600, seconds/60%60, seconds%60
+
+	if h > 0 {
+		return fmt.Sprintf("%d:%02d:%02d", h, m, s)
+	}
+
+	return fmt.Sprintf("%d:%02d", m, s)
+}
+
+func EqualEnclosures(el1, el2 EnclosureList) bool {
+	if len(el1) != len(el2) {
+		return false
+	}
+
+	for i := range el1 {
+		if *el1[i] != *el2[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// StoreEnclosures replaces the enclosures of the post in the database.
+func (p *Post) StoreEnclosures(tx *sql.Tx) error {
+	if err := p.DeleteEnclosures(tx); err != nil {
+		return err
+	}
+
+	for i, e := range p.Enclosures {
+		_, err := tx.Exec(
+			`INSERT INTO post_enclosures (post, position, url, type,
+			                              length, duration)
+			   VALUES (?, ?, ?, ?, ?, ?)`,
+			p.Id, i, e.URL, e.Type, e.Length,
+			int64(e.Duration/time.Second))
+		if err != nil {
+			return fmt.Errorf("cannot insert post enclosure: %v", err)
+		}
+	}
+
+	return nil
+}
+
+func (p *Post) DeleteEnclosures(tx *sql.Tx) error {
+	_, err := tx.Exec(`DELETE FROM post_enclosures WHERE post = ?`, p.Id)
+	if err != nil {
+		return fmt.Errorf("cannot delete post enclosures: %v", err)
+	}
+
+	return nil
+}
+
+// LoadEnclosures loads the enclosures of all posts of the list.
+func (pl PostList) LoadEnclosures(tx *sql.Tx) error {
+	if len(pl) == 0 {
+		return nil
+	}
+
+	posts := make(map[int64]*Post)
+	ids := make([]string, len(pl))
+
+	for i, p := range pl {
+		p.Enclosures = nil
+		posts[p.Id] = p
+		ids[i] = fmt.Sprintf("%d", p.Id)
+	}
+
+	rows, err := tx.Query(
+		`SELECT post, url, type, length, duration
+		   FROM post_enclosures
+		   WHERE post IN (` + strings.Join(ids, ", ") + `)
+		   ORDER BY post, position`)
+	if err != nil {
+		return fmt.Errorf("cannot load post enclosures: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id, duration int64
+		e := &Enclosure{}
+
+		err := rows.Scan(&id, &e.URL, &e.Type, &e.Length, &duration)
+		if err != nil {
+			return fmt.Errorf("invalid post enclosure: %v", err)
+		}
+
+		e.Duration = time.Duration(duration) * time.Second
+
+		if p, found := posts[id]; found {
+			p.Enclosures = append(p.Enclosures, e)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("cannot load post enclosures: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,59 @@
+// Copyright (c) 2016 Nicolas Martyanoff <khaelin@gmail.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseEnclosureDuration(t *testing.T) {
+	tests := []struct {
+		s        string
+		duration time.Duration
+	}{
+		{"", 0},
+		{"  ", 0},
+		{"0", 0},
+		{"42", 42 * time.Second},
+		{" 42 ", 42 * time.Second},
+		{"1.6", 2 * time.Second},
+		{"3600", time.Hour},
+		{"02:30", 2*time.Minute + 30*time.Second},
+		{"1:02:03", time.Hour + 2*time.Minute + 3*time.Second},
+		{"90:00", 90 * time.Minute},
+		{"168:00:00", MaxEnclosureDuration},
+
+		// Invalid durations
+		{"abc", 0},
+		{"-10", 0},
+		{"1:-1", 0},
+		{"1::2", 0},
+		{"Inf", 0},
+		{"+Inf", 0},
+		{"NaN", 0},
+		{"1e300", 0},
+		{"168:00:01", 0},
+		{"99999999999999999999", 0},
+	}
+
+	for _, test := range tests {
+		duration := ParseEnclosureDuration(test.s)
+		if duration != test.duration {
+			t.Errorf("%q: got %v, expected %v",
+				test.s, duration, test.duration)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+// Copyright (c) 2016 Nicolas Martyanoff <khaelin@gmail.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"html"
+	"strings"
+	"unicode/utf8"
+
+	nethtml "golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Excerpt returns the first blocks of an HTML fragment, for example
+// paragraphs or code blocks, whose text content does not exceed maxLen
+// characters. Blocks are never cut, except for the first one when it is too
+// long by itself: paragraphs are then truncated at a word boundary, and code
+// blocks at a line boundary. Elements which could run code or embed external
+// content are removed. The second value returned is true if part of the
+// content was left out.
+func Excerpt(content string, maxLen int) (string, bool) {
+	context := &nethtml.Node{
+		Type:     nethtml.ElementNode,
+		Data:     "div",
+		DataAtom: atom.Div,
+	}
+
+	nodes, err := nethtml.ParseFragment(strings.NewReader(content), context)
+	if err != nil {
+		// Truncated text is better than nothing
+		text := HTMLText(content)
+		excerpt := TruncateText(text, maxLen)
+		return "<p>" + html.EscapeString(excerpt) + "</p>", excerpt != text
+	}
+
+	blocks := excerptBlocks(nodes)
+
+	var buf bytes.Buffer
+	length := 0
+
+	for i, block := range blocks {
+		var blockBuf bytes.Buffer
+		for _, n := range block {
+			sanitizeNode(n)
+			nethtml.Render(&blockBuf, n)
+		}
+
+		blockHTML := blockBuf.String()
+		blockLength := utf8.RuneCountInString(HTMLText(blockHTML))
+
+		if length+blockLength <= maxLen {
+			buf.WriteString(blockHTML)
+			length += blockLength
+			continue
+		}
+
+		if i == 0 {
+			buf.WriteString(truncateBlock(block, blockHTML, maxLen))
+		}
+
+		return buf.String(), true
+	}
+
+	return buf.String(), false
+}
+
+// excerptBlocks groups the top-level nodes of a fragment in blocks. Inline
+// nodes following each other form a single block. Containers wrapping the
+// whole fragment are ignored.
+func excerptBlocks(nodes []*nethtml.Node) [][]*nethtml.Node {
+	for {
+		var elts []*nethtml.Node
+		for _, n := range nodes {
+			if n.Type == nethtml.TextNode &&
+				strings.TrimSpace(n.Data) == "" {
+				continue
+			}
+			elts = append(elts, n)
+		}
+
+		if len(elts) != 1 || !isContainerElement(elts[0]) {
+			nodes = elts
+			break
+		}
+
+		nodes = nil
+		for c := elts[0].FirstChild; c != nil; c = c.NextSibling {
+			nodes = append(nodes, c)
+		}
+	}
+
+	var blocks [][]*nethtml.Node
+	inline := false
+
+	for _, n := range nodes {
+		if n.Type == nethtml.CommentNode {
+			continue
+		}
+		if n.Type == nethtml.ElementNode && isUnsafeElement(n.Data) {
+			continue
+		}
+
+		isBlock := n.Type == nethtml.ElementNode && isBlockElement(n.Data)
+
+		if !isBlock && inline {
+			blocks[len(blocks)-1] = append(blocks[len(blocks)-1], n)
+		} else {
+			blocks = append(blocks, []*nethtml.Node{n})
+		}
+
+		inline = !isBlock
+	}
+
+	return blocks
+}
+
+func isContainerElement(n *nethtml.Node) bool {
+	if n.Type != nethtml.ElementNode {
+		return false
+	}
+
+	switch n.Data {
+	case "article", "div", "main", "section":
+		return true
+	}
+
+	return false
+}
+
+func truncateBlock(block []*nethtml.Node, blockHTML string, maxLen int) string {
+	if len(block) == 1 && block[0].Data == "pre" {
+		lines := strings.Split(nodeText(block[0]), "\n")
+
+		length := 0
+		n := 0
+		for n < len(lines) {
+			length += utf8.RuneCountInString(lines[n]) + 1
+			if length > maxLen && n > 0 {
+				break
+			}
+			n++
+		}
+
+		text := strings.Join(lines[:n], "\n")
+		return "<pre>" + html.EscapeString(text) + "</pre>"
+	}
+
+	text := TruncateText(HTMLText(blockHTML), maxLen)
+	return "<p>" + html.EscapeString(text) + "</p>"
+}
+
+// nodeText returns the text content of a node without collapsing whitespace.
+func nodeText(n *nethtml.Node) string {
+	if n.Type == nethtml.TextNode {
+		return n.Data
+	}
+
+	var buf bytes.Buffer
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		buf.WriteString(nodeText(c))
+	}
+
+	return buf.String()
+}
+
+// sanitizeNode removes scripts, embedded content and event handlers from a
+// node and its descendants.
+func sanitizeNode(n *nethtml.Node) {
+	attrs := n.Attr[:0]
+	for _, attr := range n.Attr {
+		key := strings.ToLower(attr.Key)
+		value := strings.ToLower(strings.TrimSpace(attr.Val))
+
+		if strings.HasPrefix(key, "on") {
+			continue
+		}
+		if (key == "href" || key == "src") &&
+			strings.HasPrefix(value, "javascript:") {
+			continue
+		}
+
+		attrs = append(attrs, attr)
+	}
+	n.Attr = attrs
+
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+
+		if c.Type == nethtml.ElementNode && isUnsafeElement(c.Data) {
+			n.RemoveChild(c)
+		} else {
+			sanitizeNode(c)
+		}
+
+		c = next
+	}
+}
+
+func isUnsafeElement(tag string) bool {
+	switch tag {
+	case "applet", "button", "embed", "form", "frame", "frameset",
+		"iframe", "input", "link", "meta", "object", "script",
+		"select", "style", "textarea":
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,58 @@
+// Copyright (c) 2016 Nicolas Martyanoff <khaelin@gmail.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package main
+
+import "testing"
+
+func TestExcerpt(t *testing.T) {
+	tests := []struct {
+		content   string
+		maxLen    int
+		excerpt   string
+		truncated bool
+	}{
+		{"", 100, "", false},
+		{"<p>Hello world.</p>", 100, "<p>Hello world.</p>", false},
+
+		// Blocks are kept as long as they fit
+		{"<p>One.</p><p>Two.</p><p>Three.</p>", 10,
+			"<p>One.</p><p>Two.</p>", true},
+		{"<div><p>One.</p><p>Two.</p></div>", 100,
+			"<p>One.</p><p>Two.</p>", false},
+		{"Some <em>inline</em> text<p>block</p>", 100,
+			"Some <em>inline</em> text<p>block</p>", false},
+
+		// The first block is truncated if it is too long
+		{"<p>A first paragraph which is much too long to fit.</p><p>x</p>",
+			20, "<p>A first paragraph…</p>", true},
+		{"<pre>line 1\nline 2\nline 3\nline 4</pre><p>after</p>", 15,
+			"<pre>line 1\nline 2</pre>", true},
+
+		// Unsafe content is removed
+		{`<p onclick="x()">Hi <a href="javascript:x()">link</a></p>` +
+			`<script>alert(1)</script><iframe src="x"></iframe>`, 100,
+			"<p>Hi <a>link</a></p>", false},
+		{"<!-- comment --><p>x</p>", 100, "<p>x</p>", false},
+	}
+
+	for _, test := range tests {
+		excerpt, truncated := Excerpt(test.content, test.maxLen)
+		if excerpt != test.excerpt || truncated != test.truncated {
+			t.Errorf("%q: got %q (%v), expected %q (%v)",
+				test.content, excerpt, truncated,
+				test.excerpt, test.truncated)
+		}
+	}
+}
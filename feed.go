@@ -15,10 +15,18 @@
 package main
 
 import (
+	"bytes"
 	"database/sql"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/mmcdole/gofeed"
+	"github.com/mmcdole/gofeed/rss"
 )
 
 type Feed struct {
@@ -28,17 +36,82 @@ type Feed struct {
 	Author     string // optional
 	WebsiteURL string
 	Enabled    bool
+	NextPoll   time.Time
 
-	feed *gofeed.Feed
+	Added         time.Time
+	BacklogPolicy string // applied to posts published before Added
+
+	// The most complete way posts can be displayed, as chosen by the
+	// author.
+	Display string
+
+	Filters FeedFilterList // not loaded by default
+
+	feed      *gofeed.Feed
+	fetchDate time.Time
+	header    http.Header
+	ttl       time.Duration
 }
 
 type FeedList []*Feed
 
+const (
+	MaxFeedSize = 10 * 1024 * 1024
+
+	feedDownloadTimeout = 30 * time.Second
+)
+
+var feedClient = &http.Client{Timeout: feedDownloadTimeout}
+
+// Backlog policies
+const (
+	BacklogKeep   = "keep"   // import old posts as any other post
+	BacklogHide   = "hide"   // import old posts but do not display them
+	BacklogIgnore = "ignore" // do not import old posts
+)
+
+func IsBacklogPolicy(s string) bool {
+	return s == BacklogKeep || s == BacklogHide || s == BacklogIgnore
+}
+
+// Display modes, from the most to the least complete
+const (
+	DisplayFull    = "full"    // the whole content of posts
+	DisplayExcerpt = "excerpt" // the first blocks of the content of posts
+	DisplayTitle   = "title"   // the title of posts only
+)
+
+var displayModes = []string{DisplayFull, DisplayExcerpt, DisplayTitle}
+
+func IsDisplayMode(s string) bool {
+	for _, mode := range displayModes {
+		if s == mode {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RestrictDisplayMode returns the least complete of two display modes.
+func RestrictDisplayMode(a, b string) string {
+	for i := len(displayModes) - 1; i >= 0; i-- {
+		if a == displayModes[i] || b == displayModes[i] {
+			return displayModes[i]
+		}
+	}
+
+	return DisplayFull
+}
+
 func (f *Feed) Insert(tx *sql.Tx) error {
 	res, err := tx.Exec(
-		`INSERT INTO feeds (url, title, author, website_url, enabled)
-		   VALUES (?, ?, ?, ?, ?)`,
-		f.URL, f.Title, f.Author, f.WebsiteURL, f.Enabled)
+		`INSERT INTO feeds (url, title, author, website_url, enabled,
+		                    next_poll, added, backlog_policy, display)
+		   VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		f.URL, f.Title, f.Author, f.WebsiteURL, f.Enabled,
+		Timestamp(f.NextPoll), Timestamp(f.Added), f.BacklogPolicy,
+		f.Display)
 	if err != nil {
 		return fmt.Errorf("cannot insert feed: %v", err)
 	}
@@ -59,25 +132,112 @@ func (f *Feed) Update(tx *sql.Tx) error {
 		     title = ?,
 		     author = ?,
 		     website_url = ?,
-		     enabled = ?
+		     enabled = ?,
+		     next_poll = ?,
+		     added = ?,
+		     backlog_policy = ?,
+		     display = ?
 		   WHERE id = ?`,
 		f.URL, f.Title, f.Author, f.WebsiteURL, f.Enabled,
-		f.Id)
+		Timestamp(f.NextPoll), Timestamp(f.Added), f.BacklogPolicy,
+		f.Display, f.Id)
+	if err != nil {
+		return fmt.Errorf("cannot update feed: %v", err)
+	}
+
+	return nil
+}
+
+func SetFeedDisplay(tx *sql.Tx, id int64, display string) error {
+	res, err := tx.Exec(`UPDATE feeds SET display = ? WHERE id = ?`,
+		display, id)
 	if err != nil {
 		return fmt.Errorf("cannot update feed: %v", err)
 	}
 
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("unknown feed %d", id)
+	}
+
+	return nil
+}
+
+// DeleteFeed deletes a feed, its filters, its posts and all the data
+// associated with them.
+func DeleteFeed(tx *sql.Tx, id int64) error {
+	var posts PostList
+	if err := posts.DeleteByFeed(tx, id); err != nil {
+		return err
+	}
+
+	_, err := tx.Exec(`DELETE FROM feed_filters WHERE feed = ?`, id)
+	if err != nil {
+		return fmt.Errorf("cannot delete feed filters: %v", err)
+	}
+
+	res, err := tx.Exec(`DELETE FROM feeds WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("cannot delete feed: %v", err)
+	}
+
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("unknown feed %d", id)
+	}
+
 	return nil
 }
 
 func (f *Feed) Download() error {
-	parser := gofeed.NewParser()
-	feed, err := parser.ParseURL(f.URL)
+	res, err := feedClient.Get(f.URL)
+	if err != nil {
+		return fmt.Errorf("cannot download %s: %v", f.URL, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("cannot download %s: request failed with "+
+			"status %d", f.URL, res.StatusCode)
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(res.Body, MaxFeedSize+1))
 	if err != nil {
 		return fmt.Errorf("cannot download %s: %v", f.URL, err)
 	}
 
+	if len(data) > MaxFeedSize {
+		return fmt.Errorf("cannot download %s: feed larger than %d "+
+			"bytes", f.URL, MaxFeedSize)
+	}
+
+	parser := gofeed.NewParser()
+	feed, err := parser.Parse(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("cannot parse %s: %v", f.URL, err)
+	}
+
 	f.feed = feed
+	f.fetchDate = time.Now().UTC()
+	f.header = res.Header
+	f.ttl = 0
+
+	// The ttl element is specific to RSS and is not available in the
+	// universal feed representation.
+	if feed.FeedType == "rss" {
+		rssParser := &rss.Parser{}
+		rssFeed, err := rssParser.Parse(bytes.NewReader(data))
+		if err == nil && rssFeed.TTL != "" {
+			ttl, err := strconv.Atoi(strings.TrimSpace(rssFeed.TTL))
+			if err == nil && ttl > 0 {
+				maxTTL := int(MaxPublisherPollInterval / time.Minute)
+				if ttl > maxTTL {
+					ttl = maxTTL
+				}
+
+				f.ttl = time.Duration(ttl) * time.Minute
+			}
+		}
+	}
+
 	return nil
 }
 
@@ -95,6 +255,10 @@ func (f *Feed) ExtractMetadata() {
 	}
 }
 
+// ExtractPosts returns the posts of the downloaded feed. Dates in the future
+// are replaced by the date the feed was fetched, posts published before the
+// feed was added are handled according to its backlog policy, and posts
+// rejected by the filters of the feed are disabled.
 func (f *Feed) ExtractPosts() PostList {
 	var ps PostList
 
@@ -102,10 +266,29 @@ func (f *Feed) ExtractPosts() PostList {
 		p := &Post{FeedId: f.Id, Enabled: true}
 
 		p.ReadFromGofeedItem(item)
-		if p.URL == "" || p.Title == "" || p.Date.IsZero() {
+		if p.URL == "" || p.Title == "" {
 			continue
 		}
 
+		if p.Date.After(f.fetchDate) {
+			p.Date = f.fetchDate
+			p.dateClamped = true
+		}
+
+		if !f.Added.IsZero() && !p.Date.IsZero() &&
+			p.Date.Before(f.Added) {
+			switch f.BacklogPolicy {
+			case BacklogHide:
+				p.Enabled = false
+			case BacklogIgnore:
+				continue
+			}
+		}
+
+		if p.FilteredBy = f.Filters.Apply(p); p.FilteredBy != "" {
+			p.Enabled = false
+		}
+
 		ps = append(ps, p)
 	}
 
@@ -113,8 +296,23 @@ func (f *Feed) ExtractPosts() PostList {
 }
 
 func (f *Feed) ReadFromRow(row *sql.Rows) error {
-	return row.Scan(&f.Id, &f.URL, &f.Title, &f.Author, &f.WebsiteURL,
-		&f.Enabled)
+	var nextPoll, added int64
+
+	err := row.Scan(&f.Id, &f.URL, &f.Title, &f.Author, &f.WebsiteURL,
+		&f.Enabled, &nextPoll, &added, &f.BacklogPolicy, &f.Display)
+	if err != nil {
+		return err
+	}
+
+	f.NextPoll = TimestampTime(nextPoll)
+	f.Added = TimestampTime(added)
+
+	return nil
+}
+
+// IsDue returns true if the feed should be polled at the given time.
+func (f *Feed) IsDue(now time.Time) bool {
+	return f.NextPoll.IsZero() || !now.Before(f.NextPoll)
 }
 
 func (fl FeedList) Len() int           { return len(fl) }
@@ -123,7 +321,8 @@ func (fl FeedList) Less(i, j int) bool { return fl[i].Title < fl[j].Title }
 
 func (fl *FeedList) LoadEnabled(tx *sql.Tx) error {
 	rows, err := tx.Query(
-		`SELECT id, url, title, author, website_url, enabled
+		`SELECT id, url, title, author, website_url, enabled, next_poll,
+		        added, backlog_policy, display
 		   FROM feeds
 		   WHERE enabled = 1`)
 	if err != nil {
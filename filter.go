@@ -0,0 +1,238 @@
+// Copyright (c) 2016 Nicolas Martyanoff <khaelin@gmail.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Filter actions
+const (
+	FilterInclude = "include" // only keep posts matching the filter
+	FilterExclude = "exclude" // hide posts matching the filter
+)
+
+// Filter fields
+const (
+	FilterCategory = "category"
+	FilterTitle    = "title"
+	FilterContent  = "content"
+	FilterAny      = "any"
+)
+
+// A FeedFilter is a rule deciding whether the posts of a feed are displayed
+// or not. The pattern is either a keyword, matched as a whole word without
+// regard to case (or as a whole category), or a regular expression.
+type FeedFilter struct {
+	Id      int64
+	FeedId  int64
+	Action  string
+	Field   string
+	Pattern string
+	Regexp  bool
+
+	re *regexp.Regexp
+}
+
+type FeedFilterList []*FeedFilter
+
+func IsFilterAction(s string) bool {
+	return s == FilterInclude || s == FilterExclude
+}
+
+func IsFilterField(s string) bool {
+	return s == FilterCategory || s == FilterTitle ||
+		s == FilterContent || s == FilterAny
+}
+
+func (f *FeedFilter) Compile() error {
+	if f.re != nil {
+		return nil
+	}
+
+	var re *regexp.Regexp
+	var err error
+
+	if f.Regexp {
+		re, err = regexp.Compile(f.Pattern)
+	} else {
+		re, err = regexp.Compile(
+			`(?i)(?:^|\W)` + regexp.QuoteMeta(f.Pattern) + `(?:$|\W)`)
+	}
+
+	if err != nil {
+		return fmt.Errorf("invalid filter pattern %q: %v", f.Pattern, err)
+	}
+
+	f.re = re
+	return nil
+}
+
+// String returns a description of the filter, stored with the posts it
+// filters.
+func (f *FeedFilter) String() string {
+	pattern := fmt.Sprintf("%q", f.Pattern)
+	if f.Regexp {
+		pattern = "/" + f.Pattern + "/"
+	}
+
+	return fmt.Sprintf("filter %d: %s %s %s", f.Id, f.Action, f.Field,
+		pattern)
+}
+
+func (f *FeedFilter) Match(p *Post) bool {
+	if err := f.Compile(); err != nil {
+		return false
+	}
+
+	if f.Field == FilterCategory || f.Field == FilterAny {
+		for _, category := range p.Categories {
+			if f.matchCategory(category) {
+				return true
+			}
+		}
+	}
+
+	if f.Field == FilterTitle || f.Field == FilterAny {
+		if f.re.MatchString(p.Title) {
+			return true
+		}
+	}
+
+	if f.Field == FilterContent || f.Field == FilterAny {
+		if f.re.MatchString(HTMLText(p.Content)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (f *FeedFilter) matchCategory(category string) bool {
+	category = strings.TrimSpace(category)
+
+	if f.Regexp {
+		return f.re.MatchString(category)
+	}
+
+	return strings.EqualFold(category, strings.TrimSpace(f.Pattern))
+}
+
+// Apply returns the filter responsible for hiding a post, or an empty
+// string if the post is not filtered. A post is filtered if it matches an
+// exclusion filter, or if there are inclusion filters and it does not match
+// any of them.
+func (fl FeedFilterList) Apply(p *Post) string {
+	hasIncludes := false
+	included := false
+
+	for _, f := range fl {
+		switch f.Action {
+		case FilterExclude:
+			if f.Match(p) {
+				return f.String()
+			}
+
+		case FilterInclude:
+			hasIncludes = true
+			if !included && f.Match(p) {
+				included = true
+			}
+		}
+	}
+
+	if hasIncludes && !included {
+		return "no include filter matched"
+	}
+
+	return ""
+}
+
+func (f *FeedFilter) Insert(tx *sql.Tx) error {
+	res, err := tx.Exec(
+		`INSERT INTO feed_filters (feed, action, field, pattern, regexp)
+		   VALUES (?, ?, ?, ?, ?)`,
+		f.FeedId, f.Action, f.Field, f.Pattern, f.Regexp)
+	if err != nil {
+		return fmt.Errorf("cannot insert filter: %v", err)
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("cannot retrieve filter id: %v", err)
+	}
+
+	f.Id = id
+	return nil
+}
+
+func DeleteFeedFilter(tx *sql.Tx, id int64) error {
+	res, err := tx.Exec(`DELETE FROM feed_filters WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("cannot delete filter: %v", err)
+	}
+
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("unknown filter %d", id)
+	}
+
+	return nil
+}
+
+func (f *FeedFilter) ReadFromRow(row *sql.Rows) error {
+	return row.Scan(&f.Id, &f.FeedId, &f.Action, &f.Field, &f.Pattern,
+		&f.Regexp)
+}
+
+func (fl *FeedFilterList) LoadAll(tx *sql.Tx) error {
+	return fl.Load(tx,
+		`SELECT id, feed, action, field, pattern, regexp
+		   FROM feed_filters
+		   ORDER BY feed, id`)
+}
+
+func (fl *FeedFilterList) LoadByFeed(tx *sql.Tx, feedId int64) error {
+	return fl.Load(tx,
+		`SELECT id, feed, action, field, pattern, regexp
+		   FROM feed_filters
+		   WHERE feed = ?
+		   ORDER BY id`, feedId)
+}
+
+func (fl *FeedFilterList) Load(tx *sql.Tx, query string, args ...interface{}) error {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return fmt.Errorf("cannot load filters: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		f := &FeedFilter{}
+		if err := f.ReadFromRow(rows); err != nil {
+			return fmt.Errorf("invalid filter: %v", err)
+		}
+
+		*fl = append(*fl, f)
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("cannot load filters: %v", err)
+	}
+
+	return nil
+}
@@ -15,16 +15,22 @@
 package main
 
 import (
+	"bytes"
 	"database/sql"
 	"fmt"
 	"html/template"
 	"io/ioutil"
+	"log"
+	"math"
 	"os"
 	"path"
 	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gorilla/feeds"
+	"github.com/tdewolff/minify/v2"
 )
 
 type Generator struct {
@@ -32,14 +38,51 @@ type Generator struct {
 	ShareDirPath  string
 	OutputDirPath string
 	PostsPerPage  int
-	AnalyticsId   string
+	Analytics     *Analytics
 
-	tpl *template.Template
+	Display       string // how posts are displayed in listings
+	ExcerptLength int
+
+	ImageCacheDirPath string // optional
+
+	// Link the titles of posts in listings to their page instead of
+	// their original URL.
+	PermalinkTitles bool
+
+	BaseURL        string   // the URL of the root of the website
+	RobotsDisallow []string // paths crawlers must not access
+
+	// Asset pipeline, see CopyAssets and CompressFiles
+	Minify      bool // minify stylesheets, scripts and pages
+	Fingerprint bool // insert content hashes in the name of assets
+	Compress    bool // write gzip and brotli versions of files
+
+	tpl      *template.Template
+	images   CachedImageMap
+	sitemap  []SitemapURL
+	assets   map[string]string // www-data path -> output path
+	minifier *minify.M
 }
 
+// GeneratorData contains the data common to all pages. Root is the relative
+// path of the root of the website from the page, e.g. "../" for pages in a
+// subdirectory.
 type GeneratorData struct {
-	Production  bool
-	AnalyticsId string
+	Production bool
+	Analytics  *Analytics
+	Root       string
+
+	SiteName    string
+	Title       string
+	Description string
+	URL         string // the absolute URL of the page
+	Canonical   string // the URL of the reference version of the page
+
+	// Open Graph metadata
+	OGType          string
+	PublicationDate time.Time // optional
+
+	JSONLD template.JS // optional
 }
 
 type GeneratorFeedData struct {
@@ -48,23 +91,31 @@ type GeneratorFeedData struct {
 }
 
 type GeneratorFeedsData struct {
-	Production  bool
-	AnalyticsId string
+	GeneratorData
 
 	Feeds []*GeneratorFeedData
 }
 
 type GeneratorPostData struct {
-	Feed *Feed
+	Feed      *Feed
+	FeedTitle template.HTML
 
 	Post        *Post
 	PostAuthor  template.HTML
 	PostContent template.HTML
+
+	Root     string // see GeneratorData
+	PagePath string // relative to the current page
+	TitleURL string
+	Pinned   bool
+
+	Display     string
+	PostExcerpt template.HTML
+	Truncated   bool // true if the excerpt is not the whole content
 }
 
 type GeneratorPostsData struct {
-	Production  bool
-	AnalyticsId string
+	GeneratorData
 
 	Feeds map[int64]*Feed
 
@@ -78,10 +129,48 @@ type GeneratorPostsData struct {
 	LastUpdate time.Time
 }
 
+type GeneratorPostPageData struct {
+	GeneratorData
+
+	Post GeneratorPostData
+
+	Newer *Post
+	Older *Post
+}
+
+type GeneratorTagData struct {
+	GeneratorData
+
+	Tag   string
+	Posts []GeneratorPostData
+
+	Page         int
+	PreviousPage int
+	NextPage     int
+	LastPage     int
+}
+
+type GeneratorTagsData struct {
+	GeneratorData
+
+	Tags []*GeneratorTagCloudEntry
+}
+
+type GeneratorTagCloudEntry struct {
+	Tag   string
+	Count int
+	Size  int // between 1 and 5
+}
+
 func NewGenerator() *Generator {
 	return &Generator{
 		OutputDirPath: "/tmp/planetgolang",
 		PostsPerPage:  10,
+		Analytics:     &Analytics{Provider: AnalyticsNone},
+		BaseURL:       "http://planetgolang.com",
+
+		Display:       DisplayFull,
+		ExcerptLength: 500,
 	}
 }
 
@@ -96,8 +185,9 @@ func (g *Generator) Generate(tx *sql.Tx) error {
 		return fmt.Errorf("cannot clear %s: %v", g.OutputDirPath, err)
 	}
 
-	subDirNames := []string{"js", "css", "img", "fonts"}
-	for _, name := range subDirNames {
+	g.sitemap = nil
+
+	for _, name := range AssetDirNames {
 		subOutputDirPath := path.Join(g.OutputDirPath, name)
 
 		if err := os.MkdirAll(subOutputDirPath, 0755); err != nil {
@@ -107,25 +197,16 @@ func (g *Generator) Generate(tx *sql.Tx) error {
 	}
 
 	// Copy static files
-	for _, subDirName := range subDirNames {
-		srcDirPath := path.Join(g.ShareDirPath, "www-data", subDirName)
-		files, err := ioutil.ReadDir(srcDirPath)
-		if err != nil {
-			if os.IsNotExist(err) {
-				continue
-			}
+	g.minifier = NewMinifier()
 
-			return fmt.Errorf("cannot list directory %s",
-				srcDirPath)
-		}
-
-		for _, file := range files {
-			ipath := path.Join(srcDirPath, file.Name())
-			opath := path.Join(g.OutputDirPath, subDirName, file.Name())
+	if err := g.CopyAssets(); err != nil {
+		return err
+	}
 
-			if err := CopyFile(ipath, opath); err != nil {
-				return err
-			}
+	// Copy cached images
+	if g.ImageCacheDirPath != "" {
+		if err := g.CopyCachedImages(tx); err != nil {
+			return err
 		}
 	}
 
@@ -152,13 +233,22 @@ func (g *Generator) Generate(tx *sql.Tx) error {
 		"feeds.tmpl",
 		"about.tmpl",
 		"posts.tmpl",
+		"search.tmpl",
+		"tags.tmpl",
+		"post.tmpl",
+		"analytics.tmpl",
 	}
 
 	for i, p := range tplPaths {
 		tplPaths[i] = path.Join(g.ShareDirPath, "templates", p)
 	}
 
-	tpl, err := template.ParseFiles(tplPaths...)
+	funcs := template.FuncMap{
+		"link":    g.Link,
+		"tagPage": TagPagePath,
+	}
+
+	tpl, err := template.New("").Funcs(funcs).ParseFiles(tplPaths...)
 	if err != nil {
 		return fmt.Errorf("cannot load templates: %v", err)
 	}
@@ -168,10 +258,12 @@ func (g *Generator) Generate(tx *sql.Tx) error {
 	sort.Sort(fl)
 
 	feedsData := &GeneratorFeedsData{
-		Production:  Production,
-		AnalyticsId: g.AnalyticsId,
-		Feeds:       make([]*GeneratorFeedData, len(fl)),
+		GeneratorData: g.PageData("feeds.html", "Feeds",
+			fmt.Sprintf("The %d Go-related blogs aggregated by %s.",
+				len(fl), SiteName)),
+		Feeds: make([]*GeneratorFeedData, len(fl)),
 	}
+	feedsData.JSONLD = FeedsJSONLD(fl)
 
 	for i, f := range fl {
 		feedsData.Feeds[i] = &GeneratorFeedData{
@@ -183,16 +275,28 @@ func (g *Generator) Generate(tx *sql.Tx) error {
 	if err := g.GeneratePage("feeds.html", "feeds", feedsData); err != nil {
 		return err
 	}
+	g.AddSitemapURL("feeds.html", time.Time{})
 
 	// Generate the about page
-	aboutData := &GeneratorData{
-		Production:  Production,
-		AnalyticsId: g.AnalyticsId,
-	}
+	aboutData := g.PageData("about.html", "About", "")
 
 	if err := g.GeneratePage("about.html", "about", aboutData); err != nil {
 		return err
 	}
+	g.AddSitemapURL("about.html", time.Time{})
+
+	// Generate the search page
+	searchData := g.PageData("search.html", "Search",
+		"Search the posts aggregated by "+SiteName+".")
+
+	if err := g.GeneratePage("search.html", "search", searchData); err != nil {
+		return err
+	}
+	g.AddSitemapURL("search.html", time.Time{})
+
+	if err := g.GenerateSearchIndex(tx, feeds, "search"); err != nil {
+		return fmt.Errorf("cannot generate search index: %v", err)
+	}
 
 	// Generate post pages
 	offset := 0
@@ -207,33 +311,29 @@ func (g *Generator) Generate(tx *sql.Tx) error {
 			break
 		}
 
-		postsData := make([]GeneratorPostData, len(posts))
-		for i, post := range posts {
-			feed := feeds[post.FeedId]
+		if err := posts.LoadTags(tx); err != nil {
+			return err
+		}
 
-			var author string
-			if post.Author != "" {
-				author = post.Author
-			} else if feed.Author != "" {
-				author = feed.Author
-			} else {
-				author = feed.Title
-			}
+		if err := posts.LoadEnclosures(tx); err != nil {
+			return err
+		}
 
-			postsData[i] = GeneratorPostData{
-				Feed: feed,
+		pageName := fmt.Sprintf("page-%05d.html", page)
 
-				Post:        post,
-				PostAuthor:  template.HTML(author),
-				PostContent: template.HTML(post.Content),
-			}
+		var pageData GeneratorData
+		if page == 1 {
+			pageData = g.PageData("", "", "")
+			pageData.JSONLD = WebSiteJSONLD(g.URL(""))
+		} else {
+			pageData = g.PageData(pageName,
+				fmt.Sprintf("Page %d", page), "")
 		}
 
 		data := GeneratorPostsData{
-			Production:  Production,
-			AnalyticsId: g.AnalyticsId,
+			GeneratorData: pageData,
 
-			Posts: postsData,
+			Posts: g.PostsData(posts, feeds, ""),
 
 			Page:         page,
 			PreviousPage: page - 1,
@@ -243,11 +343,16 @@ func (g *Generator) Generate(tx *sql.Tx) error {
 			LastUpdate: time.Now(),
 		}
 
-		pageName := fmt.Sprintf("page-%05d.html", page)
 		if err := g.GeneratePage(pageName, "posts", data); err != nil {
 			return err
 		}
 
+		if page == 1 {
+			g.AddSitemapURL("", posts.LastModified())
+		} else {
+			g.AddSitemapURL(pageName, posts.LastModified())
+		}
+
 		offset += len(posts)
 		page++
 	}
@@ -261,32 +366,427 @@ func (g *Generator) Generate(tx *sql.Tx) error {
 			indexPage, firstPostsPage, err)
 	}
 
-	// Generate the RSS feed
-	if err := g.GenerateFeed(tx, "rss.xml"); err != nil {
-		return fmt.Errorf("cannot generate rss feed: %v", err)
+	// Generate the RSS and Atom feeds
+	if err := g.GenerateFeeds(tx, feeds); err != nil {
+		return err
+	}
+
+	// Generate a page for each post
+	if err := g.GeneratePostPages(tx, feeds); err != nil {
+		return err
+	}
+
+	// Generate tag pages
+	if err := g.GenerateTagPages(tx, feeds); err != nil {
+		return err
+	}
+
+	// Generate files for crawlers
+	if err := g.GenerateSitemap(); err != nil {
+		return err
+	}
+
+	if err := g.GenerateRobots(); err != nil {
+		return err
+	}
+
+	// Precompress files
+	if g.Compress {
+		if err := CompressFiles(g.OutputDirPath); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
-func (g *Generator) GeneratePage(filePath string, tplName string, data interface{}) error {
-	filePath = path.Join(g.OutputDirPath, filePath)
+// PageData returns the data common to all pages for the page at a path of
+// the website. The page is its own canonical version.
+func (g *Generator) PageData(pagePath, title, description string) GeneratorData {
+	if description == "" {
+		description = SiteDescription
+	}
 
-	file, err := os.Create(filePath)
-	if err != nil {
-		return fmt.Errorf("cannot create %s: %v", filePath, err)
+	pageURL := g.URL(pagePath)
+
+	return GeneratorData{
+		Production: Production,
+		Analytics:  g.Analytics,
+		Root:       strings.Repeat("../", strings.Count(pagePath, "/")),
+
+		SiteName:    SiteName,
+		Title:       PageTitle(title),
+		Description: description,
+		URL:         pageURL,
+		Canonical:   pageURL,
+
+		OGType: "website",
+	}
+}
+
+// Link returns the URL of a file of the website relative to a page, root
+// being the relative path of the root of the website from the page (see
+// GeneratorData). Links never depend on the location of the website on its
+// server, so that it can be served from any path. Static files are replaced
+// by their fingerprinted version.
+func (g *Generator) Link(root, filePath string) string {
+	link := root + g.AssetPath(strings.TrimLeft(filePath, "/"))
+	if link == "" {
+		// An empty link would refer to the current page
+		return "./"
+	}
+
+	return link
+}
+
+func (g *Generator) PostsData(posts PostList, feeds map[int64]*Feed, root string) []GeneratorPostData {
+	postsData := make([]GeneratorPostData, len(posts))
+
+	for i, post := range posts {
+		feed := feeds[post.FeedId]
+		display := RestrictDisplayMode(g.Display, feed.Display)
+
+		postsData[i] = g.PostData(post, feed, display, root)
+	}
+
+	return postsData
+}
+
+func (g *Generator) PostData(post *Post, feed *Feed, display, root string) GeneratorPostData {
+	data := GeneratorPostData{
+		Feed:      feed,
+		FeedTitle: template.HTML(feed.Title),
+
+		Post:       post,
+		PostAuthor: template.HTML(post.AuthorName(feed)),
+		Root:       root,
+		PagePath:   g.Link(root, post.PagePath()),
+		Pinned:     post.IsPinned(time.Now()),
+
+		Display: display,
+	}
+
+	data.TitleURL = post.URL
+	if g.PermalinkTitles {
+		data.TitleURL = data.PagePath
+	}
+
+	if display == DisplayTitle {
+		return data
+	}
+
+	content := post.Content
+	if g.images != nil {
+		content = RewriteImageURLs(post, g.images,
+			g.Link(root, "img/cache/"))
+	}
+	content = HighlightCode(content)
+
+	switch display {
+	case DisplayFull:
+		data.PostContent = template.HTML(content)
+
+	case DisplayExcerpt:
+		excerpt, truncated := Excerpt(content, g.ExcerptLength)
+
+		data.PostExcerpt = template.HTML(excerpt)
+		data.Truncated = truncated
+	}
+
+	return data
+}
+
+// GeneratePostPages generates a page for each post in the posts directory.
+// Posts are displayed as allowed by the author of their feed, regardless of
+// the display mode of listings.
+func (g *Generator) GeneratePostPages(tx *sql.Tx, feeds map[int64]*Feed) error {
+	dirPath := path.Join(g.OutputDirPath, "posts")
+	if err := os.MkdirAll(dirPath, 0755); err != nil {
+		return fmt.Errorf("cannot create directory %s: %v", dirPath, err)
+	}
+
+	var posts PostList
+	if err := posts.LoadEnabled(tx); err != nil {
+		return err
+	}
+
+	// Tags and enclosures are loaded by batch to keep queries short
+	const batchSize = 100
+
+	for start := 0; start < len(posts); start += batchSize {
+		end := start + batchSize
+		if end > len(posts) {
+			end = len(posts)
+		}
+
+		batch := posts[start:end]
+
+		if err := batch.LoadTags(tx); err != nil {
+			return err
+		}
+
+		if err := batch.LoadEnclosures(tx); err != nil {
+			return err
+		}
+
+		for i, post := range batch {
+			feed := feeds[post.FeedId]
+
+			description := PostDescription(post, feed)
+
+			data := &GeneratorPostPageData{
+				GeneratorData: g.PageData(post.PagePath(),
+					post.Title, description),
+
+				Post: g.PostData(post, feed, feed.Display, "../"),
+			}
+
+			data.Canonical = post.URL
+			data.OGType = "article"
+			data.PublicationDate = post.OrderDate()
+			data.JSONLD = PostJSONLD(post, feed, description)
+			data.Post.TitleURL = post.URL
+
+			// Posts are sorted from the most recent to the oldest
+			if j := start + i; j > 0 {
+				data.Newer = posts[j-1]
+			}
+			if j := start + i; j < len(posts)-1 {
+				data.Older = posts[j+1]
+			}
+
+			err := g.GeneratePage(post.PagePath(), "post", data)
+			if err != nil {
+				return err
+			}
+
+			g.AddSitemapURL(post.PagePath(),
+				PostList{post}.LastModified())
+		}
+
+		// Tags and enclosures of the batch are not needed anymore
+		for _, post := range batch {
+			post.Tags = nil
+			post.Enclosures = nil
+		}
+	}
+
+	return nil
+}
+
+// GenerateTagPages generates a page and a RSS feed for each tag in the tags
+// directory, and the tag cloud in tags.html.
+func (g *Generator) GenerateTagPages(tx *sql.Tx, feeds map[int64]*Feed) error {
+	var tags TagCountList
+	if err := tags.LoadEnabled(tx); err != nil {
+		return err
+	}
+
+	tagsDirPath := path.Join(g.OutputDirPath, "tags")
+	if err := os.MkdirAll(tagsDirPath, 0755); err != nil {
+		return fmt.Errorf("cannot create directory %s: %v",
+			tagsDirPath, err)
+	}
+
+	maxCount := 1
+	for _, tc := range tags {
+		if tc.Count > maxCount {
+			maxCount = tc.Count
+		}
+	}
+
+	tagsData := &GeneratorTagsData{
+		GeneratorData: g.PageData("tags.html", "Tags",
+			"The tags of the posts aggregated by "+SiteName+"."),
+		Tags: make([]*GeneratorTagCloudEntry, len(tags)),
+	}
+
+	for i, tc := range tags {
+		// Tag counts usually follow a power law, so sizes are based
+		// on a logarithmic scale.
+		size := 1
+		if maxCount > 1 {
+			size += int(math.Round(4 * math.Log(float64(tc.Count)) /
+				math.Log(float64(maxCount))))
+		}
+
+		tagsData.Tags[i] = &GeneratorTagCloudEntry{
+			Tag:   tc.Tag,
+			Count: tc.Count,
+			Size:  size,
+		}
+
+		feedPosts, err := g.GenerateTagPostPages(tx, feeds, tc)
+		if err != nil {
+			return err
+		}
+
+		if len(feedPosts) > 10 {
+			feedPosts = feedPosts[:10]
+		}
+
+		if err := feedPosts.LoadEnclosures(tx); err != nil {
+			return err
+		}
+
+		feedPath := path.Join("tags", tc.Tag+".xml")
+		title := fmt.Sprintf("%s - %s", SiteName, tc.Tag)
+		link := g.URL(TagPagePath(tc.Tag, 1))
+
+		feed := g.SyndicationFeed(title, link, feedPosts, feeds)
+		if err := g.WriteRSSFeed(feedPath, feed); err != nil {
+			return fmt.Errorf("cannot generate rss feed for tag %s: %v",
+				tc.Tag, err)
+		}
+	}
+
+	if err := g.GeneratePage("tags.html", "tags", tagsData); err != nil {
+		return err
+	}
+	g.AddSitemapURL("tags.html", time.Time{})
+
+	return nil
+}
+
+// GenerateTagPostPages generates the pages listing the posts of a tag,
+// PostsPerPage posts at a time, and returns the posts of the first page.
+func (g *Generator) GenerateTagPostPages(tx *sql.Tx, feeds map[int64]*Feed, tc *TagCount) (PostList, error) {
+	var firstPosts PostList
+
+	lastPage := (tc.Count + g.PostsPerPage - 1) / g.PostsPerPage
+
+	for page := 1; page <= lastPage; page++ {
+		var posts PostList
+		err := posts.LoadRangeByTag(tx, tc.Tag, g.PostsPerPage,
+			(page-1)*g.PostsPerPage)
+		if err != nil {
+			return nil, err
+		}
+		if len(posts) == 0 {
+			break
+		}
+
+		if err := posts.LoadTags(tx); err != nil {
+			return nil, err
+		}
+
+		pagePath := TagPagePath(tc.Tag, page)
+
+		title := "Posts tagged " + tc.Tag
+		if page > 1 {
+			title += fmt.Sprintf(" - Page %d", page)
+		}
+
+		pageData := g.PageData(pagePath, title,
+			"Posts tagged "+tc.Tag+" on "+SiteName+".")
+
+		data := &GeneratorTagData{
+			GeneratorData: pageData,
+
+			Tag:   tc.Tag,
+			Posts: g.PostsData(posts, feeds, pageData.Root),
+
+			Page:         page,
+			PreviousPage: page - 1,
+			NextPage:     page + 1,
+			LastPage:     lastPage,
+		}
+
+		dirPath := path.Join(g.OutputDirPath, path.Dir(pagePath))
+		if err := os.MkdirAll(dirPath, 0755); err != nil {
+			return nil, fmt.Errorf("cannot create directory %s: %v",
+				dirPath, err)
+		}
+
+		if err := g.GeneratePage(pagePath, "tag", data); err != nil {
+			return nil, err
+		}
+		g.AddSitemapURL(pagePath, posts.LastModified())
+
+		if page == 1 {
+			firstPosts = posts
+		}
+	}
+
+	return firstPosts, nil
+}
+
+// TagPagePath returns the path of a page listing the posts of a tag. The
+// first page is tags/<tag>.html; tags only contain letters, digits and
+// dashes, so the directory of the other pages cannot collide with it.
+func TagPagePath(tag string, page int) string {
+	if page == 1 {
+		return path.Join("tags", tag+".html")
 	}
-	defer file.Close()
 
-	if err := g.tpl.ExecuteTemplate(file, tplName, data); err != nil {
+	return path.Join("tags", tag, fmt.Sprintf("page-%05d.html", page))
+}
+
+// CopyCachedImages copies the images of the image cache to img/cache in the
+// output directory.
+func (g *Generator) CopyCachedImages(tx *sql.Tx) error {
+	g.images = make(CachedImageMap)
+	if err := g.images.Load(tx); err != nil {
+		return err
+	}
+
+	dirPath := path.Join(g.OutputDirPath, "img", "cache")
+	if err := os.MkdirAll(dirPath, 0755); err != nil {
+		return fmt.Errorf("cannot create directory %s: %v", dirPath, err)
+	}
+
+	copied := make(map[string]bool)
+
+	for url, name := range g.images {
+		if copied[name] {
+			continue
+		}
+
+		ipath := path.Join(g.ImageCacheDirPath, name)
+		opath := path.Join(dirPath, name)
+
+		if err := CopyFile(ipath, opath); err != nil {
+			// The cache may have been cleared manually
+			log.Printf("error: %v", err)
+			delete(g.images, url)
+			continue
+		}
+
+		copied[name] = true
+	}
+
+	return nil
+}
+
+func (g *Generator) GeneratePage(filePath string, tplName string, data interface{}) error {
+	filePath = path.Join(g.OutputDirPath, filePath)
+
+	var buf bytes.Buffer
+	if err := g.tpl.ExecuteTemplate(&buf, tplName, data); err != nil {
 		return fmt.Errorf("cannot execute template %s: %v",
 			tplName, err)
 	}
 
+	content := buf.Bytes()
+
+	if g.Minify {
+		var err error
+
+		content, err = g.minifier.Bytes("text/html", content)
+		if err != nil {
+			return fmt.Errorf("cannot minify %s: %v", filePath, err)
+		}
+	}
+
+	if err := ioutil.WriteFile(filePath, content, 0644); err != nil {
+		return fmt.Errorf("cannot write %s: %v", filePath, err)
+	}
+
 	return nil
 }
 
-func (g *Generator) GenerateFeed(tx *sql.Tx, filePath string) error {
+// GenerateFeeds generates the RSS and Atom feeds of the last posts.
+func (g *Generator) GenerateFeeds(tx *sql.Tx, feedMap map[int64]*Feed) error {
 	// Load last posts
 	var posts PostList
 	err := posts.LoadRange(tx, 10, 0)
@@ -294,30 +794,74 @@ func (g *Generator) GenerateFeed(tx *sql.Tx, filePath string) error {
 		return err
 	}
 
+	if err := posts.LoadEnclosures(tx); err != nil {
+		return err
+	}
+
+	feed := g.SyndicationFeed(SiteName, g.URL(""), posts, feedMap)
+
+	if err := g.WriteRSSFeed("rss.xml", feed); err != nil {
+		return fmt.Errorf("cannot generate rss feed: %v", err)
+	}
+
+	if err := g.WriteAtomFeed("atom.xml", feed, posts); err != nil {
+		return fmt.Errorf("cannot generate atom feed: %v", err)
+	}
+
+	return nil
+}
+
+func (g *Generator) SyndicationFeed(title, link string, posts PostList, feedMap map[int64]*Feed) *feeds.Feed {
 	// Generate feed items
 	items := make([]*feeds.Item, len(posts))
 	for i, post := range posts {
+		// Feeds always honor the choice of the author
+		var description string
+
+		switch feedMap[post.FeedId].Display {
+		case DisplayFull:
+			description = post.Content
+		case DisplayExcerpt:
+			description, _ = Excerpt(post.Content, g.ExcerptLength)
+		}
+
 		items[i] = &feeds.Item{
 			Title:       post.Title,
 			Link:        &feeds.Link{Href: post.URL},
 			Id:          post.URL,
 			Author:      &feeds.Author{Name: post.Author},
-			Created:     post.Date,
-			Description: post.Content,
+			Created:     post.OrderDate(),
+			Description: description,
+		}
+		// RSS items can only have one enclosure
+		if len(post.Enclosures) > 0 {
+			e := post.Enclosures[0]
+
+			// The type is mandatory in RSS
+			enclosureType := e.Type
+			if enclosureType == "" {
+				enclosureType = "application/octet-stream"
+			}
+
+			items[i].Enclosure = &feeds.Enclosure{
+				Url:    e.URL,
+				Type:   enclosureType,
+				Length: strconv.FormatInt(e.Length, 10),
+			}
 		}
 	}
 
 	// Generate feed
-	now := time.Now()
-
-	feed := &feeds.Feed{
-		Title:       "Planet Golang",
-		Link:        &feeds.Link{Href: "http://planetgolang.com"},
-		Description: "An aggregator of various Go-related blogs.",
-		Created:     now,
+	return &feeds.Feed{
+		Title:       title,
+		Link:        &feeds.Link{Href: link},
+		Description: SiteDescription,
+		Created:     time.Now(),
 		Items:       items,
 	}
+}
 
+func (g *Generator) WriteRSSFeed(filePath string, feed *feeds.Feed) error {
 	rss, err := feed.ToRss()
 	if err != nil {
 		return fmt.Errorf("cannot generate rss feed: %v", err)
@@ -0,0 +1,353 @@
+// Copyright (c) 2016 Nicolas Martyanoff <khaelin@gmail.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"go/scanner"
+	"go/token"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Code blocks are highlighted with span elements whose classes start with
+// this prefix, see www-data/css/highlight.css.
+const highlightClassPrefix = "hl-"
+
+var goPredeclaredTypes = map[string]bool{
+	"bool": true, "byte": true, "complex64": true, "complex128": true,
+	"error": true, "float32": true, "float64": true, "int": true,
+	"int8": true, "int16": true, "int32": true, "int64": true,
+	"rune": true, "string": true, "uint": true, "uint8": true,
+	"uint16": true, "uint32": true, "uint64": true, "uintptr": true,
+}
+
+var goPredeclaredIdentifiers = map[string]bool{
+	"true": true, "false": true, "iota": true, "nil": true,
+	"append": true, "cap": true, "close": true, "complex": true,
+	"copy": true, "delete": true, "imag": true, "len": true,
+	"make": true, "new": true, "panic": true, "print": true,
+	"println": true, "real": true, "recover": true,
+}
+
+// Constructs which are frequent in Go code and rare in other languages.
+var goCodePatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?m)^\s*package\s+\w+\s*$`),
+	regexp.MustCompile(`\bfunc\s*(\([^)]*\)\s*)?\w*\(`),
+	regexp.MustCompile(`\w+(\s*,\s*\w+)*\s*:=`),
+	regexp.MustCompile(`\bimport\s+(\(|"|\w+\s+")`),
+	regexp.MustCompile(`\bif\s+err\s*!=\s*nil\b`),
+	regexp.MustCompile(`\b(chan|go|defer|select)\b`),
+	regexp.MustCompile(`\b(type\s+\w+\s+)?struct\s*\{`),
+	regexp.MustCompile(`\bfmt\.\w+\(`),
+}
+
+// HighlightCode highlights the Go code blocks of an HTML fragment. A code
+// block is a pre element, optionally containing a code element, whose
+// language is indicated by a class (e.g. "language-go") or, in the absence
+// of any hint, which looks like Go code. Blocks which already contain markup
+// are left untouched.
+func HighlightCode(content string) string {
+	if !strings.Contains(content, "<pre") {
+		return content
+	}
+
+	context := &html.Node{
+		Type:     html.ElementNode,
+		Data:     "div",
+		DataAtom: atom.Div,
+	}
+
+	nodes, err := html.ParseFragment(strings.NewReader(content), context)
+	if err != nil {
+		return content
+	}
+
+	highlighted := false
+
+	var visit func(*html.Node)
+	visit = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.DataAtom == atom.Pre {
+			if highlightCodeBlock(n) {
+				highlighted = true
+			}
+			return
+		}
+
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			visit(c)
+		}
+	}
+
+	for _, n := range nodes {
+		visit(n)
+	}
+
+	if !highlighted {
+		return content
+	}
+
+	var buf bytes.Buffer
+	for _, n := range nodes {
+		if err := html.Render(&buf, n); err != nil {
+			return content
+		}
+	}
+
+	return buf.String()
+}
+
+func highlightCodeBlock(pre *html.Node) bool {
+	// The code is either directly in the pre element, or in a single code
+	// element.
+	block := pre
+
+	if c := pre.FirstChild; c != nil && c.NextSibling == nil &&
+		c.Type == html.ElementNode && c.DataAtom == atom.Code {
+		block = c
+	}
+
+	var code bytes.Buffer
+	for c := block.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.TextNode {
+			return false
+		}
+
+		code.WriteString(c.Data)
+	}
+
+	switch lang := codeBlockLanguage(pre, block); lang {
+	case "go":
+	case "":
+		if !LooksLikeGoCode(code.String()) {
+			return false
+		}
+	default:
+		return false
+	}
+
+	for c := block.FirstChild; c != nil; {
+		next := c.NextSibling
+		block.RemoveChild(c)
+		c = next
+	}
+
+	for _, n := range HighlightGoCode(code.String()) {
+		block.AppendChild(n)
+	}
+
+	addClass(pre, "highlight")
+
+	return true
+}
+
+// codeBlockLanguage returns the language indicated by the classes of a code
+// block, or an empty string if there is none.
+func codeBlockLanguage(nodes ...*html.Node) string {
+	for _, n := range nodes {
+		for _, attr := range n.Attr {
+			if attr.Key != "class" {
+				continue
+			}
+
+			for _, class := range strings.Fields(attr.Val) {
+				class = strings.ToLower(class)
+
+				for _, prefix := range []string{"language-",
+					"lang-", "highlight-", "brush:"} {
+					class = strings.TrimPrefix(class, prefix)
+				}
+
+				switch class {
+				case "go", "golang":
+					return "go"
+				case "bash", "c", "c++", "cpp", "console",
+					"css", "diff", "html", "java",
+					"javascript", "js", "json", "make",
+					"makefile", "protobuf", "proto",
+					"python", "ruby", "rust", "sh", "shell",
+					"sql", "text", "toml", "xml", "yaml":
+					return class
+				}
+			}
+		}
+	}
+
+	return ""
+}
+
+// LooksLikeGoCode returns true if a piece of text contains at least two
+// constructs typical of Go code.
+func LooksLikeGoCode(code string) bool {
+	n := 0
+
+	for _, re := range goCodePatterns {
+		if re.MatchString(code) {
+			n++
+			if n >= 2 {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// HighlightGoCode returns the nodes representing a piece of Go code, with
+// tokens wrapped in span elements. Invalid or incomplete code is tolerated.
+func HighlightGoCode(code string) []*html.Node {
+	var nodes []*html.Node
+
+	addText := func(s string) {
+		if s != "" {
+			nodes = append(nodes, &html.Node{
+				Type: html.TextNode,
+				Data: s,
+			})
+		}
+	}
+
+	src := []byte(code)
+
+	fset := token.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(src))
+
+	var s scanner.Scanner
+	s.Init(file, src, func(token.Position, string) {},
+		scanner.ScanComments)
+
+	offset := 0
+
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+
+		// Automatically inserted semicolons do not exist in the source
+		if tok == token.SEMICOLON && lit != ";" {
+			continue
+		}
+
+		start := file.Offset(pos)
+		if start < offset {
+			continue
+		}
+
+		text := lit
+		if text == "" {
+			text = tok.String()
+		}
+
+		end := tokenEnd(code, start, tok, text)
+
+		addText(code[offset:start])
+
+		class := goTokenClass(tok, lit)
+		if class == "" {
+			addText(code[start:end])
+		} else {
+			nodes = append(nodes, highlightSpan(class, code[start:end]))
+		}
+
+		offset = end
+	}
+
+	addText(code[offset:])
+
+	return nodes
+}
+
+// tokenEnd returns the offset of the end of a token in the source code.
+// Comments and raw strings can contain carriage returns which are not part
+// of the literal returned by the scanner, so their end is looked for in the
+// source code.
+func tokenEnd(code string, start int, tok token.Token, text string) int {
+	end := -1
+
+	switch {
+	case tok == token.COMMENT && strings.HasPrefix(text, "/*"):
+		if i := strings.Index(code[start+2:], "*/"); i >= 0 {
+			end = start + 2 + i + 2
+		}
+
+	case tok == token.COMMENT:
+		if i := strings.IndexByte(code[start:], '\n'); i >= 0 {
+			end = start + i
+		}
+
+	case tok == token.STRING && strings.HasPrefix(text, "`"):
+		if i := strings.IndexByte(code[start+1:], '`'); i >= 0 {
+			end = start + 1 + i + 1
+		}
+
+	default:
+		end = start + len(text)
+	}
+
+	if end < 0 || end > len(code) {
+		end = len(code)
+	}
+
+	return end
+}
+
+func goTokenClass(tok token.Token, lit string) string {
+	switch {
+	case tok == token.COMMENT:
+		return "comment"
+	case tok == token.STRING || tok == token.CHAR:
+		return "string"
+	case tok == token.INT || tok == token.FLOAT || tok == token.IMAG:
+		return "number"
+	case tok.IsKeyword():
+		return "keyword"
+	case tok == token.IDENT && goPredeclaredTypes[lit]:
+		return "type"
+	case tok == token.IDENT && goPredeclaredIdentifiers[lit]:
+		return "builtin"
+	}
+
+	return ""
+}
+
+func highlightSpan(class, text string) *html.Node {
+	span := &html.Node{
+		Type:     html.ElementNode,
+		Data:     "span",
+		DataAtom: atom.Span,
+		Attr: []html.Attribute{
+			{Key: "class", Val: highlightClassPrefix + class},
+		},
+	}
+
+	span.AppendChild(&html.Node{Type: html.TextNode, Data: text})
+
+	return span
+}
+
+func addClass(n *html.Node, class string) {
+	for i, attr := range n.Attr {
+		if attr.Key == "class" {
+			n.Attr[i].Val = strings.TrimSpace(attr.Val + " " + class)
+			return
+		}
+	}
+
+	n.Attr = append(n.Attr, html.Attribute{Key: "class", Val: class})
+}
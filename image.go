@@ -0,0 +1,479 @@
+// Copyright (c) 2016 Nicolas Martyanoff <khaelin@gmail.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+const (
+	DefaultMaxImageSize = 5 * 1024 * 1024
+
+	// Failed downloads are retried after this delay.
+	ImageRetryDelay = 7 * 24 * time.Hour
+
+	imageDownloadTimeout = 30 * time.Second
+)
+
+// The types of images which can be cached, with the extension of the cached
+// files. SVG images are excluded since they can contain scripts.
+var imageTypes = map[string]string{
+	"image/gif":  ".gif",
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/webp": ".webp",
+}
+
+// An ImageCache is a directory containing a copy of the images referenced in
+// the content of posts. Files are named after the SHA-256 hash of their
+// content, so that an image referenced with different URLs is only stored
+// once.
+type ImageCache struct {
+	DirPath string
+	MaxSize int64
+
+	client *http.Client
+}
+
+// A CachedImage is a download attempt of an image. Failed downloads are
+// recorded so that they are not retried on each update.
+type CachedImage struct {
+	URL     string
+	Hash    string
+	Type    string
+	Size    int64
+	Fetched time.Time
+	Error   string
+}
+
+// CachedImageMap associates the URLs of successfully cached images with the
+// name of their file in the cache.
+type CachedImageMap map[string]string
+
+// Networks which are not reachable from the internet, in addition to those
+// identified by the methods of net.IP (see IsPublicIP).
+var nonPublicNetworks = parseCIDRs(
+	"0.0.0.0/8",      // "this" network
+	"100.64.0.0/10",  // carrier-grade NAT
+	"192.0.0.0/24",   // IETF protocol assignments
+	"198.18.0.0/15",  // benchmarking
+	"240.0.0.0/4",    // reserved, including broadcast
+	"64:ff9b:1::/48", // local-use IPv4/IPv6 translation
+	"2001:db8::/32",  // documentation
+)
+
+func NewImageCache(dirPath string) *ImageCache {
+	// Image URLs come from feeds, i.e. from third parties: connections
+	// to addresses which are not public, e.g. the loopback interface or
+	// cloud metadata services, are refused, including after redirections
+	// since the address is checked each time a connection is made.
+	dialer := &net.Dialer{
+		Timeout: imageDownloadTimeout,
+		Control: dialPublicAddress,
+	}
+
+	transport := &http.Transport{
+		DialContext:         dialer.DialContext,
+		TLSHandshakeTimeout: imageDownloadTimeout,
+	}
+
+	return &ImageCache{
+		DirPath: dirPath,
+		MaxSize: DefaultMaxImageSize,
+
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   imageDownloadTimeout,
+		},
+	}
+}
+
+func dialPublicAddress(network, address string, conn syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	if ip := net.ParseIP(host); ip == nil || !IsPublicIP(ip) {
+		return fmt.Errorf("connection to non-public address %s refused",
+			host)
+	}
+
+	return nil
+}
+
+// IsPublicIP returns true if an address can be reached from the internet.
+func IsPublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+
+	for _, network := range nonPublicNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func parseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, len(cidrs))
+
+	for i, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(fmt.Sprintf("invalid network %q: %v", cidr, err))
+		}
+
+		networks[i] = network
+	}
+
+	return networks
+}
+
+// FileName returns the name of the file of the image in the cache.
+func (img *CachedImage) FileName() string {
+	return img.Hash + imageTypes[img.Type]
+}
+
+func (img *CachedImage) Insert(tx *sql.Tx) error {
+	_, err := tx.Exec(
+		`INSERT OR REPLACE INTO images (url, hash, type, size, fetched,
+		                                error)
+		   VALUES (?, ?, ?, ?, ?, ?)`,
+		img.URL, img.Hash, img.Type, img.Size, Timestamp(img.Fetched),
+		img.Error)
+	if err != nil {
+		return fmt.Errorf("cannot insert image: %v", err)
+	}
+
+	return nil
+}
+
+// NeedsDownload returns true if an image was never downloaded, or if the
+// last download failed long enough ago to be retried.
+func (c *ImageCache) NeedsDownload(tx *sql.Tx, imageURL string, now time.Time) (bool, error) {
+	var fetched int64
+	var errString string
+
+	row := tx.QueryRow(
+		`SELECT fetched, error FROM images WHERE url = ?`, imageURL)
+	if err := row.Scan(&fetched, &errString); err == sql.ErrNoRows {
+		return true, nil
+	} else if err != nil {
+		return false, fmt.Errorf("cannot load image: %v", err)
+	}
+
+	if errString == "" {
+		return false, nil
+	}
+
+	return now.Sub(TimestampTime(fetched)) >= ImageRetryDelay, nil
+}
+
+// Download fetches an image and stores it in the cache. Errors are reported
+// in the Error field of the image.
+func (c *ImageCache) Download(imageURL string, now time.Time) *CachedImage {
+	img := &CachedImage{
+		URL:     imageURL,
+		Fetched: now,
+	}
+
+	if err := c.download(img); err != nil {
+		img.Error = err.Error()
+	}
+
+	return img
+}
+
+func (c *ImageCache) download(img *CachedImage) error {
+	res, err := c.client.Get(img.URL)
+	if err != nil {
+		return fmt.Errorf("cannot download image: %v", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		return fmt.Errorf("cannot download image: status %d",
+			res.StatusCode)
+	}
+
+	if res.ContentLength > c.MaxSize {
+		return fmt.Errorf("image too large (%d bytes)",
+			res.ContentLength)
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(res.Body, c.MaxSize+1))
+	if err != nil {
+		return fmt.Errorf("cannot read image: %v", err)
+	}
+
+	if int64(len(data)) > c.MaxSize {
+		return fmt.Errorf("image too large (more than %d bytes)",
+			c.MaxSize)
+	}
+
+	// Servers often return incorrect content types, so we rely on the
+	// content itself.
+	img.Type = http.DetectContentType(data)
+	if _, found := imageTypes[img.Type]; !found {
+		return fmt.Errorf("unsupported image type %s", img.Type)
+	}
+
+	hash := sha256.Sum256(data)
+	img.Hash = hex.EncodeToString(hash[:])
+	img.Size = int64(len(data))
+
+	return c.store(img.FileName(), data)
+}
+
+func (c *ImageCache) store(name string, data []byte) error {
+	filePath := path.Join(c.DirPath, name)
+
+	if _, err := os.Stat(filePath); err == nil {
+		return nil
+	}
+
+	if err := os.MkdirAll(c.DirPath, 0755); err != nil {
+		return fmt.Errorf("cannot create directory %s: %v",
+			c.DirPath, err)
+	}
+
+	// Write then rename so that the cache never contains partial files
+	tmpPath := filePath + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("cannot write %s: %v", tmpPath, err)
+	}
+
+	if err := os.Rename(tmpPath, filePath); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("cannot rename %s: %v", tmpPath, err)
+	}
+
+	return nil
+}
+
+func (m CachedImageMap) Load(tx *sql.Tx) error {
+	rows, err := tx.Query(
+		`SELECT url, hash, type FROM images WHERE error = ''`)
+	if err != nil {
+		return fmt.Errorf("cannot load images: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var img CachedImage
+		if err := rows.Scan(&img.URL, &img.Hash, &img.Type); err != nil {
+			return fmt.Errorf("invalid image: %v", err)
+		}
+
+		m[img.URL] = img.FileName()
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("cannot load images: %v", err)
+	}
+
+	return nil
+}
+
+// PostImageURLs returns the absolute URLs of the images referenced in the
+// content of a post. Relative URLs are resolved using the URL of the post.
+func PostImageURLs(p *Post) []string {
+	var urls []string
+
+	seen := make(map[string]bool)
+
+	z := html.NewTokenizer(strings.NewReader(p.Content))
+
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			return urls
+		}
+
+		if tt != html.StartTagToken && tt != html.SelfClosingTagToken {
+			continue
+		}
+
+		name, hasAttr := z.TagName()
+		if atom.Lookup(name) != atom.Img {
+			continue
+		}
+
+		for hasAttr {
+			var key, value []byte
+			key, value, hasAttr = z.TagAttr()
+
+			if string(key) != "src" {
+				continue
+			}
+
+			imageURL := resolveImageURL(p.URL, string(value))
+			if imageURL != "" && !seen[imageURL] {
+				seen[imageURL] = true
+				urls = append(urls, imageURL)
+			}
+		}
+	}
+}
+
+// RewriteImageURLs replaces the source of the images of the content of a post
+// which are in the cache by the path of the cached file, prefixed by dirPath.
+// Alternative sources, in the srcset attribute of images or in the source
+// elements of pictures, are removed since they reference remote images.
+func RewriteImageURLs(p *Post, images CachedImageMap, dirPath string) string {
+	if len(images) == 0 || !strings.Contains(p.Content, "<img") {
+		return p.Content
+	}
+
+	context := &html.Node{
+		Type:     html.ElementNode,
+		Data:     "div",
+		DataAtom: atom.Div,
+	}
+
+	nodes, err := html.ParseFragment(strings.NewReader(p.Content), context)
+	if err != nil {
+		return p.Content
+	}
+
+	rewritten := false
+	var pictures []*html.Node
+
+	var rewrite func(*html.Node)
+	rewrite = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.DataAtom == atom.Img {
+			if rewriteImageNode(n, p.URL, images, dirPath) {
+				rewritten = true
+
+				parent := n.Parent
+				if parent != nil && parent.DataAtom == atom.Picture {
+					pictures = append(pictures, parent)
+				}
+			}
+		}
+
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			rewrite(c)
+		}
+	}
+
+	for _, n := range nodes {
+		rewrite(n)
+	}
+
+	for _, picture := range pictures {
+		removePictureSources(picture)
+	}
+
+	if !rewritten {
+		return p.Content
+	}
+
+	var buf bytes.Buffer
+	for _, n := range nodes {
+		if err := html.Render(&buf, n); err != nil {
+			return p.Content
+		}
+	}
+
+	return buf.String()
+}
+
+func rewriteImageNode(n *html.Node, baseURL string, images CachedImageMap, dirPath string) bool {
+	var name string
+	for _, attr := range n.Attr {
+		if attr.Key == "src" {
+			name = images[resolveImageURL(baseURL, attr.Val)]
+		}
+	}
+
+	if name == "" {
+		return false
+	}
+
+	attrs := n.Attr[:0]
+	for _, attr := range n.Attr {
+		switch attr.Key {
+		case "src":
+			attr.Val = dirPath + name
+		case "srcset", "sizes":
+			continue
+		}
+
+		attrs = append(attrs, attr)
+	}
+	n.Attr = attrs
+
+	return true
+}
+
+func removePictureSources(n *html.Node) {
+	var sources []*html.Node
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && c.DataAtom == atom.Source {
+			sources = append(sources, c)
+		}
+	}
+
+	for _, source := range sources {
+		n.RemoveChild(source)
+	}
+}
+
+func resolveImageURL(baseURL, imageURL string) string {
+	imageURL = strings.TrimSpace(imageURL)
+	if imageURL == "" || strings.HasPrefix(imageURL, "data:") {
+		return ""
+	}
+
+	u, err := url.Parse(imageURL)
+	if err != nil {
+		return ""
+	}
+
+	if base, err := url.Parse(baseURL); err == nil {
+		u = base.ResolveReference(u)
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return ""
+	}
+
+	u.Fragment = ""
+
+	return u.String()
+}
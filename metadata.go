@@ -0,0 +1,170 @@
+// Copyright (c) 2016 Nicolas Martyanoff <khaelin@gmail.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"strings"
+	"time"
+)
+
+const (
+	SiteName        = "Planet Golang"
+	SiteDescription = "An aggregator of various Go-related blogs."
+
+	// The maximum length of page descriptions; longer descriptions are
+	// truncated by most websites anyway.
+	pageDescriptionLength = 200
+)
+
+// JSON-LD structured data, see https://schema.org. Only the properties used
+// by search engines are included.
+
+type jsonldThing struct {
+	Context string `json:"@context,omitempty"`
+	Type    string `json:"@type"`
+	Name    string `json:"name,omitempty"`
+	URL     string `json:"url,omitempty"`
+}
+
+type jsonldBlogPosting struct {
+	jsonldThing
+
+	Headline         string       `json:"headline"`
+	Description      string       `json:"description,omitempty"`
+	DatePublished    string       `json:"datePublished"`
+	DateModified     string       `json:"dateModified,omitempty"`
+	Author           jsonldThing  `json:"author"`
+	MainEntityOfPage string       `json:"mainEntityOfPage"`
+	IsPartOf         *jsonldThing `json:"isPartOf,omitempty"`
+	WordCount        int          `json:"wordCount,omitempty"`
+	Keywords         string       `json:"keywords,omitempty"`
+}
+
+type jsonldItemList struct {
+	jsonldThing
+
+	ItemListElement []jsonldListItem `json:"itemListElement"`
+}
+
+type jsonldListItem struct {
+	Type     string      `json:"@type"`
+	Position int         `json:"position"`
+	Item     jsonldThing `json:"item"`
+}
+
+// PageTitle returns the title of a page, or the name of the website if the
+// title is empty.
+func PageTitle(title string) string {
+	if title == "" {
+		return SiteName
+	}
+
+	return title + " - " + SiteName
+}
+
+// PostDescription returns a short plain text description of a post.
+func PostDescription(post *Post, feed *Feed) string {
+	if feed.Display != DisplayTitle {
+		text := HTMLProseText(post.Content)
+		if text != "" {
+			return TruncateText(text, pageDescriptionLength)
+		}
+	}
+
+	return post.Title + " by " + HTMLText(post.AuthorName(feed))
+}
+
+// PostJSONLD returns the structured data of the page of a post.
+func PostJSONLD(post *Post, feed *Feed, description string) template.JS {
+	data := jsonldBlogPosting{
+		jsonldThing: jsonldThing{
+			Context: "https://schema.org",
+			Type:    "BlogPosting",
+			URL:     post.URL,
+		},
+
+		Headline:      post.Title,
+		Description:   description,
+		DatePublished: post.OrderDate().UTC().Format(time.RFC3339),
+		Author: jsonldThing{
+			Type: "Person",
+			Name: HTMLText(post.AuthorName(feed)),
+		},
+		MainEntityOfPage: post.URL,
+		IsPartOf: &jsonldThing{
+			Type: "Blog",
+			Name: HTMLText(feed.Title),
+			URL:  feed.WebsiteURL,
+		},
+		WordCount: post.WordCount,
+		Keywords:  strings.Join(post.Tags, ", "),
+	}
+
+	if !post.LastChanged.IsZero() {
+		data.DateModified = post.LastChanged.UTC().Format(time.RFC3339)
+	}
+
+	return encodeJSONLD(data)
+}
+
+// FeedsJSONLD returns the structured data of the list of feeds.
+func FeedsJSONLD(fl FeedList) template.JS {
+	data := jsonldItemList{
+		jsonldThing: jsonldThing{
+			Context: "https://schema.org",
+			Type:    "ItemList",
+			Name:    "Feeds aggregated by " + SiteName,
+		},
+
+		ItemListElement: make([]jsonldListItem, len(fl)),
+	}
+
+	for i, f := range fl {
+		data.ItemListElement[i] = jsonldListItem{
+			Type:     "ListItem",
+			Position: i + 1,
+			Item: jsonldThing{
+				Type: "Blog",
+				Name: HTMLText(f.Title),
+				URL:  f.WebsiteURL,
+			},
+		}
+	}
+
+	return encodeJSONLD(data)
+}
+
+// WebSiteJSONLD returns the structured data of the home page.
+func WebSiteJSONLD(siteURL string) template.JS {
+	return encodeJSONLD(jsonldThing{
+		Context: "https://schema.org",
+		Type:    "WebSite",
+		Name:    SiteName,
+		URL:     siteURL,
+	})
+}
+
+func encodeJSONLD(data interface{}) template.JS {
+	// Marshal escapes <, > and &, so the result can be safely included
+	// in a script element.
+	content, err := json.Marshal(data)
+	if err != nil {
+		return ""
+	}
+
+	return template.JS(content)
+}
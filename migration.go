@@ -0,0 +1,371 @@
+// Copyright (c) 2016 Nicolas Martyanoff <khaelin@gmail.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+)
+
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+
+	// An optional function called once all pending migrations have been
+	// applied, i.e. with the last version of the schema, in the same
+	// transaction.
+	Fn func(*sql.Tx) error
+}
+
+// Migrations is the ordered list of schema changes. The version of the
+// schema of a database is the version of the last migration applied to it,
+// and is stored in the user_version pragma. Migrations must never be
+// modified once released: add a new one instead.
+var Migrations = []Migration{
+	{1, "initial schema", `
+CREATE TABLE feeds(
+    id INTEGER PRIMARY KEY,
+    url TEXT NOT NULL UNIQUE,
+    title TEXT NOT NULL,
+    author TEXT NOT NULL,
+    website_url TEXT NOT NULL,
+    enabled BOOLEAN NOT NULL
+);
+
+CREATE TABLE posts(
+    id INTEGER PRIMARY KEY,
+    guid TEXT NOT NULL,
+    url TEXT NOT NULL UNIQUE,
+    feed INTEGER NOT NULL REFERENCES feeds(id),
+    date INTEGER NOT NULL, -- unix timestamp
+    title TEXT NOT NULL,
+    author TEXT NOT NULL, -- overrides feeds.author when not empty
+    content TEXT NOT NULL,
+    enabled BOOLEAN NOT NULL
+);
+
+CREATE VIEW v_posts AS
+    SELECT id, feed, date(date, "unixepoch") AS datestr, url, title, enabled
+      FROM posts
+      ORDER BY date DESC;
+`, nil},
+
+	{2, "feed poll scheduling", `
+ALTER TABLE feeds ADD COLUMN next_poll INTEGER NOT NULL DEFAULT 0;
+`, nil},
+
+	// FTS5 requires the sqlite_fts5 build tag, see DB.CheckFTS5
+	{3, "full-text search", `
+CREATE VIRTUAL TABLE posts_fts USING fts5(title, author, content);
+`, RebuildSearchIndex},
+
+	{4, "post pruning", `
+ALTER TABLE posts ADD COLUMN content_pruned BOOLEAN NOT NULL DEFAULT 0;
+
+CREATE TABLE deleted_posts(
+    feed INTEGER NOT NULL REFERENCES feeds(id),
+    key TEXT NOT NULL, -- see Post.Key()
+    PRIMARY KEY (feed, key)
+);
+`, nil},
+
+	{5, "post revisions", `
+CREATE TABLE post_revisions(
+    id INTEGER PRIMARY KEY,
+    post INTEGER NOT NULL REFERENCES posts(id),
+    replaced_date INTEGER NOT NULL, -- unix timestamp
+    guid TEXT NOT NULL,
+    url TEXT NOT NULL,
+    date INTEGER NOT NULL, -- unix timestamp
+    title TEXT NOT NULL,
+    author TEXT NOT NULL,
+    content TEXT NOT NULL
+);
+
+CREATE INDEX post_revisions_post ON post_revisions(post);
+`, nil},
+
+	{6, "post first seen and last changed dates", `
+ALTER TABLE posts ADD COLUMN first_seen INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE posts ADD COLUMN last_changed INTEGER NOT NULL DEFAULT 0;
+
+-- The publication date is the best approximation we have for existing posts
+UPDATE posts SET first_seen = date;
+
+UPDATE posts
+  SET last_changed = (SELECT max(replaced_date)
+                        FROM post_revisions
+                        WHERE post = posts.id)
+  WHERE id IN (SELECT post FROM post_revisions);
+`, nil},
+
+	{7, "post date sanity checks", `
+ALTER TABLE feeds ADD COLUMN added INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE feeds ADD COLUMN backlog_policy TEXT NOT NULL DEFAULT 'keep';
+
+UPDATE posts
+  SET date = min(date, CAST(strftime('%s', 'now') AS INTEGER)),
+      first_seen = min(first_seen, CAST(strftime('%s', 'now') AS INTEGER));
+`, nil},
+
+	{8, "duplicate post detection", `
+ALTER TABLE posts ADD COLUMN canonical_url TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX posts_canonical_url ON posts(canonical_url);
+`, UpdateCanonicalURLs},
+
+	{9, "post rejections", `
+CREATE TABLE post_rejections(
+    feed INTEGER NOT NULL REFERENCES feeds(id),
+    key TEXT NOT NULL, -- see Post.Key()
+    url TEXT NOT NULL,
+    title TEXT NOT NULL,
+    error TEXT NOT NULL,
+    date INTEGER NOT NULL, -- unix timestamp
+    PRIMARY KEY (feed, key)
+);
+`, nil},
+
+	{10, "feed filters", `
+CREATE TABLE feed_filters(
+    id INTEGER PRIMARY KEY,
+    feed INTEGER NOT NULL REFERENCES feeds(id),
+    action TEXT NOT NULL, -- include, exclude
+    field TEXT NOT NULL, -- category, title, content, any
+    pattern TEXT NOT NULL,
+    regexp BOOLEAN NOT NULL
+);
+
+ALTER TABLE posts ADD COLUMN filtered_by TEXT NOT NULL DEFAULT '';
+`, nil},
+
+	{11, "post moderation", `
+ALTER TABLE posts ADD COLUMN moderated BOOLEAN NOT NULL DEFAULT 0;
+ALTER TABLE posts ADD COLUMN pinned_until INTEGER NOT NULL DEFAULT 0;
+`, nil},
+
+	{12, "post tags", `
+CREATE TABLE post_tags(
+    post INTEGER NOT NULL REFERENCES posts(id),
+    tag TEXT NOT NULL,
+    PRIMARY KEY (post, tag)
+);
+
+CREATE INDEX post_tags_tag ON post_tags(tag);
+
+CREATE TABLE tag_aliases(
+    alias TEXT PRIMARY KEY,
+    tag TEXT NOT NULL
+);
+`, nil},
+
+	{13, "feed display mode", `
+ALTER TABLE feeds ADD COLUMN display TEXT NOT NULL DEFAULT 'full';
+`, nil},
+
+	{14, "post reading time", `
+ALTER TABLE posts ADD COLUMN word_count INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE posts ADD COLUMN reading_time INTEGER NOT NULL DEFAULT 0; -- seconds
+`, UpdateReadingTimes},
+
+	{15, "image cache", `
+CREATE TABLE images(
+    url TEXT PRIMARY KEY,
+    hash TEXT NOT NULL, -- sha256 of the content
+    type TEXT NOT NULL,
+    size INTEGER NOT NULL,
+    fetched INTEGER NOT NULL, -- unix timestamp
+    error TEXT NOT NULL -- empty if the download succeeded
+);
+`, nil},
+
+	{16, "post enclosures", `
+CREATE TABLE post_enclosures(
+    post INTEGER NOT NULL REFERENCES posts(id),
+    position INTEGER NOT NULL,
+    url TEXT NOT NULL,
+    type TEXT NOT NULL,
+    length INTEGER NOT NULL, -- bytes, 0 if unknown
+    duration INTEGER NOT NULL, -- seconds, 0 if unknown
+    PRIMARY KEY (post, position)
+);
+`, nil},
+
+	{17, "email digests", `
+CREATE TABLE digests(
+    id INTEGER PRIMARY KEY,
+    date INTEGER NOT NULL, -- unix timestamp, end of the period covered
+    nb_posts INTEGER NOT NULL
+);
+`, nil},
+
+	{18, "canonical URLs with ref query parameters", `
+-- Canonical URLs are computed again by the finalization function.
+`, UpdateCanonicalURLs},
+
+	{19, "duplicate posts", `
+CREATE TABLE duplicate_posts(
+    feed INTEGER NOT NULL REFERENCES feeds(id),
+    key TEXT NOT NULL, -- see Post.Key()
+    original INTEGER NOT NULL REFERENCES posts(id),
+    PRIMARY KEY (feed, key)
+);
+
+CREATE INDEX duplicate_posts_original ON duplicate_posts(original);
+`, nil},
+}
+
+func LastSchemaVersion() int {
+	return Migrations[len(Migrations)-1].Version
+}
+
+func (db *DB) SchemaVersion() (int, error) {
+	var version int
+
+	row := db.conn.QueryRow(`PRAGMA user_version`)
+	if err := row.Scan(&version); err != nil {
+		return -1, fmt.Errorf("cannot read schema version: %v", err)
+	}
+
+	if version > 0 {
+		return version, nil
+	}
+
+	// Databases created before migrations were introduced have a version
+	// of 0 but already contain the initial schema, possibly with the feed
+	// poll scheduling column.
+	hasFeeds, err := db.HasTable("feeds")
+	if err != nil {
+		return -1, err
+	} else if !hasFeeds {
+		return 0, nil
+	}
+
+	hasNextPoll, err := db.HasColumn("feeds", "next_poll")
+	if err != nil {
+		return -1, err
+	} else if hasNextPoll {
+		return 2, nil
+	}
+
+	return 1, nil
+}
+
+func (db *DB) HasTable(name string) (bool, error) {
+	var count int
+
+	row := db.conn.QueryRow(
+		`SELECT count(*)
+		   FROM sqlite_master
+		   WHERE type = 'table' AND name = ?`, name)
+	if err := row.Scan(&count); err != nil {
+		return false, fmt.Errorf("cannot look for table %s: %v", name, err)
+	}
+
+	return count > 0, nil
+}
+
+func (db *DB) HasColumn(table, name string) (bool, error) {
+	var count int
+
+	row := db.conn.QueryRow(
+		`SELECT count(*)
+		   FROM pragma_table_info(?)
+		   WHERE name = ?`, table, name)
+	if err := row.Scan(&count); err != nil {
+		return false, fmt.Errorf("cannot look for column %s.%s: %v",
+			table, name, err)
+	}
+
+	return count > 0, nil
+}
+
+// Initialize creates the schema of a new database.
+func (db *DB) Initialize() error {
+	version, err := db.SchemaVersion()
+	if err != nil {
+		return err
+	}
+
+	if version != 0 {
+		return fmt.Errorf("database already initialized (schema "+
+			"version %d)", version)
+	}
+
+	return db.applyMigrations(version)
+}
+
+// Migrate applies all pending migrations to an existing database.
+func (db *DB) Migrate() error {
+	version, err := db.SchemaVersion()
+	if err != nil {
+		return err
+	}
+
+	if version == 0 {
+		return fmt.Errorf("database not initialized, use init-db")
+	}
+
+	return db.applyMigrations(version)
+}
+
+func (db *DB) applyMigrations(version int) error {
+	if version > LastSchemaVersion() {
+		return fmt.Errorf("database schema version %d is newer than "+
+			"the last version supported (%d)",
+			version, LastSchemaVersion())
+	}
+
+	// All pending migrations and their finalization functions are
+	// applied in a single transaction, so that the schema version is never
+	// updated if any of them fails.
+	return db.WithTx(func(tx *sql.Tx) error {
+		var fns []Migration
+
+		for _, m := range Migrations {
+			if m.Version <= version {
+				continue
+			}
+
+			log.Printf("applying migration %d (%s)", m.Version, m.Name)
+
+			if _, err := tx.Exec(m.SQL); err != nil {
+				return fmt.Errorf("cannot apply migration %d: %v",
+					m.Version, err)
+			}
+
+			if m.Fn != nil {
+				fns = append(fns, m)
+			}
+		}
+
+		for _, m := range fns {
+			if err := m.Fn(tx); err != nil {
+				return fmt.Errorf("cannot finalize migration "+
+					"%d: %v", m.Version, err)
+			}
+		}
+
+		query := fmt.Sprintf(`PRAGMA user_version = %d`,
+			LastSchemaVersion())
+		if _, err := tx.Exec(query); err != nil {
+			return fmt.Errorf("cannot update schema version: %v", err)
+		}
+
+		return nil
+	})
+}
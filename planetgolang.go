@@ -17,9 +17,15 @@ package main
 import (
 	"database/sql"
 	"fmt"
+	"io/ioutil"
 	"log"
+	"net/mail"
+	"net/url"
 	"os"
 	"path"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/galdor/go-cmdline"
 )
@@ -36,9 +42,34 @@ func main() {
 	}
 
 	cmdline.AddCommand("help", "print help and exit")
+	cmdline.AddCommand("init-db", "create the schema of a new database")
+	cmdline.AddCommand("migrate", "update the schema of the database")
 	cmdline.AddCommand("add-feed", "add a new feed")
-	cmdline.AddCommand("update", "update all feeds")
+	cmdline.AddCommand("delete-feed",
+		"delete a feed and all its posts")
+	cmdline.AddCommand("set-feed-display",
+		"choose how the posts of a feed can be displayed")
+	cmdline.AddCommand("add-filter", "add a filter to a feed")
+	cmdline.AddCommand("delete-filter", "delete a filter")
+	cmdline.AddCommand("list-filters", "list the filters of all feeds")
+	cmdline.AddCommand("filtered-posts", "list the posts hidden by filters")
+	cmdline.AddCommand("add-tag-alias", "declare a tag as an alias of another")
+	cmdline.AddCommand("hide-post", "hide a post")
+	cmdline.AddCommand("unhide-post", "display a hidden post")
+	cmdline.AddCommand("pin-post", "display a post first until a date")
+	cmdline.AddCommand("unpin-post", "stop pinning a post")
+	cmdline.AddCommand("update", "update feeds which are due")
 	cmdline.AddCommand("generate", "generate the website")
+	cmdline.AddCommand("cache-images",
+		"download the images of all posts to the image cache")
+	cmdline.AddCommand("digest", "generate an email digest of new posts")
+	cmdline.AddCommand("post-history", "show the revisions of a post")
+	cmdline.AddCommand("prune", "remove old posts or their content")
+	cmdline.AddCommand("rejected-posts",
+		"list the posts which could not be stored")
+	cmdline.AddCommand("search", "search posts")
+	cmdline.AddCommand("rebuild-search-index",
+		"rebuild the full-text search index")
 
 	cmdline.Parse(os.Args)
 
@@ -51,12 +82,52 @@ func main() {
 	case "help":
 		cmdline.PrintUsage(os.Stdout)
 		os.Exit(0)
+	case "init-db":
+		fun = CLICmdInitDb
+	case "migrate":
+		fun = CLICmdMigrate
 	case "add-feed":
 		fun = CLICmdAddFeed
+	case "delete-feed":
+		fun = CLICmdDeleteFeed
+	case "set-feed-display":
+		fun = CLICmdSetFeedDisplay
+	case "add-filter":
+		fun = CLICmdAddFilter
+	case "delete-filter":
+		fun = CLICmdDeleteFilter
+	case "list-filters":
+		fun = CLICmdListFilters
+	case "filtered-posts":
+		fun = CLICmdFilteredPosts
+	case "add-tag-alias":
+		fun = CLICmdAddTagAlias
+	case "hide-post":
+		fun = CLICmdHidePost
+	case "unhide-post":
+		fun = CLICmdUnhidePost
+	case "pin-post":
+		fun = CLICmdPinPost
+	case "unpin-post":
+		fun = CLICmdUnpinPost
 	case "update":
 		fun = CLICmdUpdate
 	case "generate":
 		fun = CLICmdGenerate
+	case "cache-images":
+		fun = CLICmdCacheImages
+	case "digest":
+		fun = CLICmdDigest
+	case "post-history":
+		fun = CLICmdPostHistory
+	case "prune":
+		fun = CLICmdPrune
+	case "rejected-posts":
+		fun = CLICmdRejectedPosts
+	case "search":
+		fun = CLICmdSearch
+	case "rebuild-search-index":
+		fun = CLICmdRebuildSearchIndex
 	}
 
 	log.SetFlags(log.Ltime)
@@ -68,17 +139,69 @@ func main() {
 		log.Fatalf("cannot open database: %v", err)
 	}
 
+	if cmd != "init-db" && cmd != "migrate" {
+		if err := db.Migrate(); err != nil {
+			log.Fatalf("cannot migrate database: %v", err)
+		}
+	}
+
 	arg0 := fmt.Sprintf("%s %s", os.Args[0], cmd)
 	fun(append([]string{arg0}, args...), db)
 
 	db.Close()
 }
 
+func CLICmdInitDb(args []string, db *DB) {
+	// Options
+	cmdline := cmdline.New()
+	cmdline.Parse(args)
+
+	// Create the schema
+	if err := db.Initialize(); err != nil {
+		log.Fatalf("cannot initialize database: %v", err)
+	}
+
+	log.Printf("database initialized with schema version %d",
+		LastSchemaVersion())
+}
+
+func CLICmdMigrate(args []string, db *DB) {
+	// Options
+	cmdline := cmdline.New()
+	cmdline.Parse(args)
+
+	// Apply pending migrations
+	version, err := db.SchemaVersion()
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	if version == LastSchemaVersion() {
+		log.Printf("database schema is up to date (version %d)", version)
+		return
+	}
+
+	if err := db.Migrate(); err != nil {
+		log.Fatalf("cannot migrate database: %v", err)
+	}
+
+	log.Printf("database migrated from schema version %d to %d",
+		version, LastSchemaVersion())
+}
+
 func CLICmdAddFeed(args []string, db *DB) {
 	// Options
 	cmdline := cmdline.New()
 
 	cmdline.AddOption("a", "author", "name", "the author of the feed")
+	cmdline.AddOption("b", "backlog", "policy",
+		"what to do with posts published before the feed is added "+
+			"(keep, hide or ignore)")
+	cmdline.SetOptionDefault("backlog", BacklogKeep)
+	cmdline.AddOption("", "display", "mode",
+		"the most complete way posts can be displayed (full, excerpt "+
+			"or title)")
+	cmdline.SetOptionDefault("display", DisplayFull)
 
 	cmdline.AddArgument("url", "the url of the feed")
 
@@ -88,10 +211,24 @@ func CLICmdAddFeed(args []string, db *DB) {
 	url := cmdline.ArgumentValue("url")
 	author := cmdline.OptionValue("author")
 
+	backlogPolicy := cmdline.OptionValue("backlog")
+	if !IsBacklogPolicy(backlogPolicy) {
+		log.Fatalf("invalid backlog policy %q", backlogPolicy)
+	}
+
+	display := cmdline.OptionValue("display")
+	if !IsDisplayMode(display) {
+		log.Fatalf("invalid display mode %q", display)
+	}
+
 	feed := &Feed{
 		URL:     url,
 		Author:  author,
 		Enabled: true,
+
+		Added:         time.Now().UTC(),
+		BacklogPolicy: backlogPolicy,
+		Display:       display,
 	}
 
 	if err := feed.Download(); err != nil {
@@ -115,7 +252,299 @@ func CLICmdAddFeed(args []string, db *DB) {
 	}
 }
 
+func CLICmdDeleteFeed(args []string, db *DB) {
+	// Options
+	cmdline := cmdline.New()
+
+	cmdline.AddArgument("feed-id", "the identifier of the feed")
+
+	cmdline.Parse(args)
+
+	// Delete the feed
+	feedId, err := strconv.ParseInt(cmdline.ArgumentValue("feed-id"), 10, 64)
+	if err != nil {
+		log.Fatalf("invalid feed id")
+	}
+
+	err = db.WithTx(func(tx *sql.Tx) error {
+		return DeleteFeed(tx, feedId)
+	})
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	log.Printf("feed %d deleted", feedId)
+}
+
+func CLICmdSetFeedDisplay(args []string, db *DB) {
+	// Options
+	cmdline := cmdline.New()
+
+	cmdline.AddArgument("feed-id", "the identifier of the feed")
+	cmdline.AddArgument("mode", "the display mode (full, excerpt or title)")
+
+	cmdline.Parse(args)
+
+	// Update the feed
+	feedId, err := strconv.ParseInt(cmdline.ArgumentValue("feed-id"), 10, 64)
+	if err != nil {
+		log.Fatalf("invalid feed id")
+	}
+
+	display := cmdline.ArgumentValue("mode")
+	if !IsDisplayMode(display) {
+		log.Fatalf("invalid display mode %q", display)
+	}
+
+	err = db.WithTx(func(tx *sql.Tx) error {
+		return SetFeedDisplay(tx, feedId, display)
+	})
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+}
+
+func CLICmdAddFilter(args []string, db *DB) {
+	// Options
+	cmdline := cmdline.New()
+
+	cmdline.AddOption("a", "action", "action",
+		"the action of the filter (include or exclude)")
+	cmdline.SetOptionDefault("action", FilterExclude)
+	cmdline.AddOption("f", "field", "field",
+		"the field the filter applies to (category, title, content "+
+			"or any)")
+	cmdline.SetOptionDefault("field", FilterAny)
+	cmdline.AddFlag("r", "regexp",
+		"interpret the pattern as a regular expression")
+
+	cmdline.AddArgument("feed-id", "the identifier of the feed")
+	cmdline.AddArgument("pattern", "the keyword or regular expression")
+
+	cmdline.Parse(args)
+
+	// Create the filter
+	feedId, err := strconv.ParseInt(cmdline.ArgumentValue("feed-id"), 10, 64)
+	if err != nil {
+		log.Fatalf("invalid feed id")
+	}
+
+	filter := &FeedFilter{
+		FeedId:  feedId,
+		Action:  cmdline.OptionValue("action"),
+		Field:   cmdline.OptionValue("field"),
+		Pattern: cmdline.ArgumentValue("pattern"),
+		Regexp:  cmdline.IsOptionSet("regexp"),
+	}
+
+	if !IsFilterAction(filter.Action) {
+		log.Fatalf("invalid filter action %q", filter.Action)
+	}
+	if !IsFilterField(filter.Field) {
+		log.Fatalf("invalid filter field %q", filter.Field)
+	}
+	if err := filter.Compile(); err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	if err := db.WithTx(filter.Insert); err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	log.Printf("%s added; it will be applied on the next update "+
+		"of feed %d", filter, filter.FeedId)
+}
+
+func CLICmdDeleteFilter(args []string, db *DB) {
+	// Options
+	cmdline := cmdline.New()
+
+	cmdline.AddArgument("id", "the identifier of the filter")
+
+	cmdline.Parse(args)
+
+	// Delete the filter
+	id, err := strconv.ParseInt(cmdline.ArgumentValue("id"), 10, 64)
+	if err != nil {
+		log.Fatalf("invalid filter id")
+	}
+
+	err = db.WithTx(func(tx *sql.Tx) error {
+		return DeleteFeedFilter(tx, id)
+	})
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+}
+
+func CLICmdListFilters(args []string, db *DB) {
+	// Options
+	cmdline := cmdline.New()
+	cmdline.Parse(args)
+
+	// Load filters
+	var filters FeedFilterList
+	if err := db.WithTx(filters.LoadAll); err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	for _, filter := range filters {
+		fmt.Printf("feed %d  %s\n", filter.FeedId, filter)
+	}
+}
+
+func CLICmdFilteredPosts(args []string, db *DB) {
+	// Options
+	cmdline := cmdline.New()
+	cmdline.Parse(args)
+
+	// Load filtered posts
+	var posts PostList
+	if err := db.WithTx(posts.LoadFiltered); err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	for _, post := range posts {
+		fmt.Printf("%d  feed %d  %s  %s\n", post.Id, post.FeedId,
+			post.OrderDate().Format("2006-01-02"), post.Title)
+		fmt.Printf("    %s\n", post.FilteredBy)
+	}
+}
+
+func CLICmdAddTagAlias(args []string, db *DB) {
+	// Options
+	cmdline := cmdline.New()
+
+	cmdline.AddArgument("alias", "the alias")
+	cmdline.AddArgument("tag", "the tag the alias refers to")
+
+	cmdline.Parse(args)
+
+	alias := NormalizeTag(cmdline.ArgumentValue("alias"))
+	tag := NormalizeTag(cmdline.ArgumentValue("tag"))
+
+	if alias == "" || tag == "" {
+		log.Fatalf("invalid empty tag")
+	} else if alias == tag {
+		log.Fatalf("a tag cannot be an alias of itself")
+	}
+
+	// Create the alias
+	err := db.WithTx(func(tx *sql.Tx) error {
+		var err error
+		tag, err = AddTagAlias(tx, alias, tag)
+		return err
+	})
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	log.Printf("tag %s is now an alias of %s", alias, tag)
+}
+
+func CLICmdHidePost(args []string, db *DB) {
+	CLIModeratePost(args, db, func(post *Post) {
+		post.Enabled = false
+		post.Moderated = true
+	})
+}
+
+func CLICmdUnhidePost(args []string, db *DB) {
+	CLIModeratePost(args, db, func(post *Post) {
+		post.Enabled = true
+		post.Moderated = true
+	})
+}
+
+func CLICmdPinPost(args []string, db *DB) {
+	// Options
+	cmdline := cmdline.New()
+
+	cmdline.AddArgument("post", "the identifier or url of the post")
+	cmdline.AddArgument("until", "the last day (UTC) the post is "+
+		"pinned, included (YYYY-MM-DD)")
+
+	cmdline.Parse(args)
+
+	day, err := time.Parse("2006-01-02", cmdline.ArgumentValue("until"))
+	if err != nil {
+		log.Fatalf("invalid date: %v", err)
+	}
+
+	// The post stays pinned until the end of the day
+	until := day.AddDate(0, 0, 1)
+
+	// Pin the post
+	CLIUpdatePost(db, cmdline.ArgumentValue("post"), func(post *Post) {
+		post.PinnedUntil = until.UTC()
+	})
+}
+
+func CLICmdUnpinPost(args []string, db *DB) {
+	CLIModeratePost(args, db, func(post *Post) {
+		post.PinnedUntil = time.Time{}
+	})
+}
+
+func CLIModeratePost(args []string, db *DB, fn func(*Post)) {
+	// Options
+	cmdline := cmdline.New()
+
+	cmdline.AddArgument("post", "the identifier or url of the post")
+
+	cmdline.Parse(args)
+
+	// Update the post
+	CLIUpdatePost(db, cmdline.ArgumentValue("post"), fn)
+}
+
+func CLIUpdatePost(db *DB, idOrURL string, fn func(*Post)) {
+	var post Post
+
+	err := db.WithTx(func(tx *sql.Tx) error {
+		if err := post.LoadByIdOrURL(tx, idOrURL); err != nil {
+			return err
+		}
+
+		fn(&post)
+
+		return post.Update(tx)
+	})
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	log.Printf("post %d updated", post.Id)
+}
+
 func CLICmdUpdate(args []string, db *DB) {
+	// Options
+	cmdline := cmdline.New()
+
+	cmdline.AddFlag("f", "force",
+		"update all feeds, even those which are not due yet")
+	cmdline.AddOption("", "prune-content-after", "days",
+		"drop the content of posts older than a number of days")
+	cmdline.AddOption("", "prune-after", "days",
+		"delete posts older than a number of days")
+	cmdline.AddOption("", "image-cache", "path",
+		"download the images of new and modified posts to a directory")
+
+	cmdline.Parse(args)
+
+	force := cmdline.IsOptionSet("force")
+
+	var imageCache *ImageCache
+	if cmdline.IsOptionSet("image-cache") {
+		imageCache = NewImageCache(cmdline.OptionValue("image-cache"))
+	}
+
+	prunePolicy := NewPrunePolicy(
+		CLIDaysOptionValue(cmdline, "prune-content-after"),
+		CLIDaysOptionValue(cmdline, "prune-after"),
+		time.Now().UTC())
+
+	// Load feeds and tag aliases
 	var feeds FeedList
 	if err := db.WithTx(feeds.LoadEnabled); err != nil {
 		log.Fatalf("%v", err)
@@ -123,47 +552,193 @@ func CLICmdUpdate(args []string, db *DB) {
 
 	log.Printf("%d feeds loaded", len(feeds))
 
+	tagAliases := make(TagAliases)
+	if err := db.WithTx(tagAliases.Load); err != nil {
+		log.Fatalf("%v", err)
+	}
+
 	// TODO parallelize
-	for _, feed := range feeds {
-		log.Printf("updating feed %s", feed.URL)
+	now := time.Now().UTC()
 
-		if err := feed.Download(); err != nil {
-			log.Printf("error: %v", err)
+	for _, feed := range feeds {
+		if !force && !feed.IsDue(now) {
 			continue
 		}
 
-		// Update feed metadata
-		feed.ExtractMetadata()
+		log.Printf("updating feed %s", feed.URL)
 
-		if err := db.WithTx(feed.Update); err != nil {
+		if err := feed.Download(); err != nil {
 			log.Printf("error: %v", err)
+			continue
 		}
 
-		// Load posts and merge new ones
+		// Load posts and filters
 		var posts PostList
+		var ignoredKeys map[string]bool
 		err := db.WithTx(func(tx *sql.Tx) error {
-			return posts.LoadByFeed(tx, feed.Id)
+			if err := posts.LoadByFeed(tx, feed.Id); err != nil {
+				return err
+			}
+
+			if err := posts.LoadTags(tx); err != nil {
+				return err
+			}
+
+			if err := posts.LoadEnclosures(tx); err != nil {
+				return err
+			}
+
+			feed.Filters = nil
+			if err := feed.Filters.LoadByFeed(tx, feed.Id); err != nil {
+				return err
+			}
+
+			keys, err := LoadDeletedPostKeys(tx, feed.Id)
+			if err != nil {
+				return err
+			}
+
+			duplicateKeys, err := LoadDuplicatePostKeys(tx, feed.Id)
+			if err != nil {
+				return err
+			}
+
+			for key := range duplicateKeys {
+				keys[key] = true
+			}
+
+			ignoredKeys = keys
+			return nil
 		})
 		if err != nil {
 			log.Printf("error: %v", err)
 			continue
 		}
 
-		extractedPosts := feed.ExtractPosts()
+		// Extract posts, ignoring those which were pruned and those
+		// which are duplicates of posts of other feeds
+		var extractedPosts PostList
+		for _, post := range feed.ExtractPosts() {
+			if !ignoredKeys[post.Key()] {
+				post.Tags = NormalizeTags(post.Categories, tagAliases)
+				extractedPosts = append(extractedPosts, post)
+			}
+		}
+
+		// Update feed metadata and schedule the next poll
+		feed.ExtractMetadata()
+
+		interval := feed.PollInterval(posts.Merge(extractedPosts))
+		feed.NextPoll = now.Add(interval)
+
+		if err := db.WithTx(feed.Update); err != nil {
+			log.Printf("error: %v", err)
+		}
+
+		// Merge new posts
 		newPosts, updatedPosts := posts.Diff(extractedPosts)
 
-		// Update posts
+		// Update posts; each post is stored in its own savepoint so that
+		// an invalid post does not prevent the others from being stored.
+		var nbNew, nbUpdated, nbDuplicates, nbRejected int
+		var storedPosts PostList
+
+		reject := func(tx *sql.Tx, post *Post, err error) error {
+			log.Printf("rejecting post %s: %v", post.URL, err)
+			nbRejected++
+
+			return RejectPost(tx, post, err, now)
+		}
+
 		err = db.WithTx(func(tx *sql.Tx) error {
 			for _, post := range updatedPosts {
-				if err := post.Update(tx); err != nil {
-					return err
+				err := WithSavepoint(tx, "post", func() error {
+					if post.modified {
+						err := post.SaveRevision(tx, now)
+						if err != nil {
+							return err
+						}
+
+						post.LastChanged = now
+					}
+
+					if err := post.Update(tx); err != nil {
+						return err
+					}
+
+					if err := post.StoreTags(tx); err != nil {
+						return err
+					}
+
+					if err := post.StoreEnclosures(tx); err != nil {
+						return err
+					}
+
+					return ClearPostRejection(tx, post)
+				})
+				if err != nil {
+					if err := reject(tx, post, err); err != nil {
+						return err
+					}
+					continue
+				}
+
+				nbUpdated++
+
+				if post.modified {
+					storedPosts = append(storedPosts, post)
 				}
 			}
 
 			for _, post := range newPosts {
-				if err := post.Insert(tx); err != nil {
-					return err
+				var dup *Post
+
+				err := WithSavepoint(tx, "post", func() error {
+					var err error
+
+					dup, err = FindDuplicatePost(tx, post)
+					if err != nil {
+						return err
+					} else if dup != nil {
+						// Do not consider it as a new post
+						// again while the original exists
+						return RecordDuplicatePost(tx, post,
+							dup)
+					}
+
+					post.FirstSeen = now
+
+					if err := post.Insert(tx); err != nil {
+						return err
+					}
+
+					if err := post.StoreTags(tx); err != nil {
+						return err
+					}
+
+					if err := post.StoreEnclosures(tx); err != nil {
+						return err
+					}
+
+					return ClearPostRejection(tx, post)
+				})
+				if err != nil {
+					if err := reject(tx, post, err); err != nil {
+						return err
+					}
+					continue
 				}
+
+				if dup != nil {
+					log.Printf("ignoring post %s: duplicate "+
+						"of post %d (%s)", post.URL, dup.Id,
+						dup.URL)
+					nbDuplicates++
+					continue
+				}
+
+				nbNew++
+				storedPosts = append(storedPosts, post)
 			}
 
 			return nil
@@ -173,8 +748,18 @@ func CLICmdUpdate(args []string, db *DB) {
 			continue
 		}
 
-		log.Printf("%s: %d new posts, %d updated posts",
-			feed.URL, len(newPosts), len(updatedPosts))
+		log.Printf("%s: %d new posts, %d updated posts, %d duplicate "+
+			"posts, %d rejected posts, next poll in %v", feed.URL,
+			nbNew, nbUpdated, nbDuplicates, nbRejected, interval)
+
+		if imageCache != nil {
+			CLICacheImages(db, imageCache, storedPosts)
+		}
+	}
+
+	// Prune old posts
+	if !prunePolicy.IsEmpty() {
+		CLIPrune(db, prunePolicy, false, false)
 	}
 }
 
@@ -182,8 +767,34 @@ func CLICmdGenerate(args []string, db *DB) {
 	// Options
 	cmdline := cmdline.New()
 
+	cmdline.AddOption("", "analytics", "provider",
+		"the analytics provider (none, ga, plausible, matomo or custom)")
 	cmdline.AddOption("", "analytics-id", "id",
-		"the google analytics identifier")
+		"the tracking id (ga), domain (plausible) or site id (matomo)")
+	cmdline.AddOption("", "analytics-url", "url",
+		"the url of the script (plausible) or server (matomo)")
+	cmdline.AddOption("", "analytics-snippet", "path",
+		"a file containing the html snippet of the custom provider")
+	cmdline.AddFlag("", "analytics-honor-dnt",
+		"disable analytics for browsers sending do not track")
+	cmdline.AddOption("", "display", "mode",
+		"how posts are displayed in listings (full, excerpt or title)")
+	cmdline.SetOptionDefault("display", DisplayFull)
+	cmdline.AddOption("", "excerpt-length", "characters",
+		"the maximum length of post excerpts")
+	cmdline.SetOptionDefault("excerpt-length", "500")
+	cmdline.AddOption("", "image-cache", "path",
+		"serve cached images instead of the original ones")
+	cmdline.AddFlag("", "permalink-titles",
+		"link post titles to their page instead of their original url")
+	cmdline.AddOption("", "base-url", "url",
+		"the url of the root of the website, possibly with a path")
+	cmdline.SetOptionDefault("base-url", "http://planetgolang.com")
+	cmdline.AddOption("", "robots-disallow", "paths",
+		"a comma-separated list of paths crawlers must not access")
+	cmdline.AddFlag("", "optimize",
+		"minify, fingerprint and compress files (always enabled "+
+			"in production)")
 	cmdline.AddOption("", "share-dir", "path",
 		"the directory containing data files")
 	if Production {
@@ -198,14 +809,77 @@ func CLICmdGenerate(args []string, db *DB) {
 
 	// Generate the website
 	outputDirPath := cmdline.ArgumentValue("output")
-	log.Printf("generating website in %s", outputDirPath)
+
+	display := cmdline.OptionValue("display")
+	if !IsDisplayMode(display) {
+		log.Fatalf("invalid display mode %q", display)
+	}
+
+	excerptLength, err := strconv.Atoi(cmdline.OptionValue("excerpt-length"))
+	if err != nil || excerptLength <= 0 {
+		log.Fatalf("invalid excerpt length")
+	}
+
+	analytics := &Analytics{
+		Provider: cmdline.OptionValue("analytics"),
+		Id:       cmdline.OptionValue("analytics-id"),
+		URL:      cmdline.OptionValue("analytics-url"),
+		HonorDNT: cmdline.IsOptionSet("analytics-honor-dnt"),
+	}
+
+	if analytics.Provider == "" {
+		// For compatibility with previous versions, a tracking id
+		// alone selects google analytics.
+		if analytics.Id != "" {
+			analytics.Provider = AnalyticsGoogle
+		} else {
+			analytics.Provider = AnalyticsNone
+		}
+	}
+
+	if cmdline.IsOptionSet("analytics-snippet") {
+		err := analytics.LoadSnippet(cmdline.OptionValue("analytics-snippet"))
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+
+	if err := analytics.Check(); err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	log.Printf("generating website in %s", outputDirPath)
 
 	gen := NewGenerator()
-	gen.AnalyticsId = cmdline.OptionValue("analytics-id")
+	gen.Display = display
+	gen.ExcerptLength = excerptLength
+	gen.Analytics = analytics
 	gen.ShareDirPath = cmdline.OptionValue("share-dir")
 	gen.OutputDirPath = outputDirPath
+	if cmdline.IsOptionSet("image-cache") {
+		gen.ImageCacheDirPath = cmdline.OptionValue("image-cache")
+	}
+	gen.PermalinkTitles = cmdline.IsOptionSet("permalink-titles")
 
-	err := db.WithTx(func(tx *sql.Tx) error {
+	optimize := Production || cmdline.IsOptionSet("optimize")
+	gen.Minify = optimize
+	gen.Fingerprint = optimize
+	gen.Compress = optimize
+
+	gen.BaseURL = cmdline.OptionValue("base-url")
+	if u, err := url.Parse(gen.BaseURL); err != nil || u.Host == "" {
+		log.Fatalf("invalid base url %q", gen.BaseURL)
+	}
+
+	if cmdline.IsOptionSet("robots-disallow") {
+		for _, p := range strings.Split(cmdline.OptionValue("robots-disallow"), ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				gen.RobotsDisallow = append(gen.RobotsDisallow, p)
+			}
+		}
+	}
+
+	err = db.WithTx(func(tx *sql.Tx) error {
 		return gen.Generate(tx)
 	})
 	if err != nil {
@@ -213,3 +887,483 @@ func CLICmdGenerate(args []string, db *DB) {
 	}
 
 }
+
+func CLICmdDigest(args []string, db *DB) {
+	// Options
+	cmdline := cmdline.New()
+
+	cmdline.AddOption("", "since", "date",
+		"include posts seen after a date (default: since the last "+
+			"digest)")
+	cmdline.AddOption("", "from", "address", "the sender of the digest")
+	cmdline.AddOption("", "to", "addresses",
+		"a comma-separated list of recipients")
+	cmdline.AddOption("", "subject", "text", "the subject of the message")
+	cmdline.AddOption("o", "output", "path",
+		"write the message to a file instead of sending it")
+	cmdline.SetOptionDefault("output", "-")
+	cmdline.AddOption("", "smtp-server", "host:port",
+		"send the message using an smtp server")
+	cmdline.AddOption("", "smtp-user", "user",
+		"the user used to authenticate to the smtp server")
+	cmdline.AddOption("", "smtp-password-file", "path",
+		"a file containing the password of the smtp user")
+	cmdline.AddFlag("", "record",
+		"record a digest written to a file as if it had been sent")
+	cmdline.AddOption("", "base-url", "url",
+		"the url of the root of the website")
+	cmdline.SetOptionDefault("base-url", "http://planetgolang.com")
+	cmdline.AddOption("", "share-dir", "path",
+		"the directory containing data files")
+	if Production {
+		cmdline.SetOptionDefault("share-dir", ShareDir)
+	} else {
+		cmdline.SetOptionDefault("share-dir", ".")
+	}
+
+	cmdline.Parse(args)
+
+	msg := &DigestMessage{
+		Date: time.Now().UTC(),
+	}
+
+	if !cmdline.IsOptionSet("from") {
+		log.Fatalf("missing sender address")
+	}
+
+	from, err := mail.ParseAddress(cmdline.OptionValue("from"))
+	if err != nil {
+		log.Fatalf("invalid sender address: %v", err)
+	}
+	msg.From = from
+
+	if !cmdline.IsOptionSet("to") {
+		log.Fatalf("missing recipient address")
+	}
+
+	msg.To, err = mail.ParseAddressList(cmdline.OptionValue("to"))
+	if err != nil {
+		log.Fatalf("invalid recipient address: %v", err)
+	}
+
+	var password string
+	if cmdline.IsOptionSet("smtp-password-file") {
+		filePath := cmdline.OptionValue("smtp-password-file")
+
+		data, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			log.Fatalf("cannot read %s: %v", filePath, err)
+		}
+
+		password = strings.TrimSpace(string(data))
+	}
+
+	baseURL := cmdline.OptionValue("base-url")
+	if u, err := url.Parse(baseURL); err != nil || u.Host == "" {
+		log.Fatalf("invalid base url %q", baseURL)
+	}
+
+	// Load posts
+	digest := &Digest{
+		Until: msg.Date,
+	}
+
+	if cmdline.IsOptionSet("since") {
+		digest.Since, err = time.Parse("2006-01-02",
+			cmdline.OptionValue("since"))
+		if err != nil {
+			log.Fatalf("invalid date: %v", err)
+		}
+	}
+
+	err = db.WithTx(func(tx *sql.Tx) error {
+		if digest.Since.IsZero() {
+			date, err := LastDigestDate(tx)
+			if err != nil {
+				return err
+			}
+
+			if date.IsZero() {
+				date = digest.Until.Add(-DigestPeriod)
+			}
+
+			digest.Since = date
+		}
+
+		return digest.Load(tx)
+	})
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	if len(digest.Posts) == 0 {
+		log.Printf("no new post since %s",
+			digest.Since.Format("2006-01-02 15:04:05Z07:00"))
+		return
+	}
+
+	// Render the digest
+	title := fmt.Sprintf("%s digest for %s", SiteName,
+		digest.Until.Format("January 2, 2006"))
+
+	msg.Subject = title
+	if cmdline.IsOptionSet("subject") {
+		msg.Subject = cmdline.OptionValue("subject")
+	}
+
+	data := digest.Data(title, baseURL)
+
+	msg.Text, msg.HTML, err = data.Render(cmdline.OptionValue("share-dir"))
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	// Send the message
+	if cmdline.IsOptionSet("smtp-server") {
+		server := cmdline.OptionValue("smtp-server")
+
+		log.Printf("sending digest with %d posts to %d recipients",
+			len(digest.Posts), len(msg.To))
+
+		err := msg.Send(server, cmdline.OptionValue("smtp-user"), password)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+	} else {
+		content, err := msg.Encode()
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+
+		outputPath := cmdline.OptionValue("output")
+		if outputPath == "-" {
+			_, err = os.Stdout.Write(content)
+		} else {
+			err = ioutil.WriteFile(outputPath, content, 0644)
+		}
+		if err != nil {
+			log.Fatalf("cannot write message: %v", err)
+		}
+	}
+
+	// Record the digest; digests which are not sent are usually previews
+	sent := cmdline.IsOptionSet("smtp-server")
+	if !sent && !cmdline.IsOptionSet("record") {
+		return
+	}
+
+	if err := db.WithTx(digest.Record); err != nil {
+		log.Fatalf("%v", err)
+	}
+}
+
+func CLICmdCacheImages(args []string, db *DB) {
+	// Options
+	cmdline := cmdline.New()
+
+	cmdline.AddArgument("path", "the image cache directory")
+
+	cmdline.Parse(args)
+
+	// Cache images
+	var posts PostList
+	if err := db.WithTx(posts.LoadEnabled); err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	imageCache := NewImageCache(cmdline.ArgumentValue("path"))
+	CLICacheImages(db, imageCache, posts)
+}
+
+// CLICacheImages downloads the images of a list of posts which are not
+// already in the cache. Images are downloaded outside of any transaction
+// since it can take a long time.
+func CLICacheImages(db *DB, cache *ImageCache, posts PostList) {
+	now := time.Now().UTC()
+
+	var urls []string
+	seen := make(map[string]bool)
+
+	err := db.WithTx(func(tx *sql.Tx) error {
+		for _, post := range posts {
+			for _, imageURL := range PostImageURLs(post) {
+				if seen[imageURL] {
+					continue
+				}
+				seen[imageURL] = true
+
+				ok, err := cache.NeedsDownload(tx, imageURL, now)
+				if err != nil {
+					return err
+				} else if ok {
+					urls = append(urls, imageURL)
+				}
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		log.Printf("error: %v", err)
+		return
+	}
+
+	if len(urls) == 0 {
+		return
+	}
+
+	images := make([]*CachedImage, len(urls))
+	nbErrors := 0
+
+	for i, imageURL := range urls {
+		images[i] = cache.Download(imageURL, now)
+
+		if images[i].Error != "" {
+			log.Printf("cannot cache image %s: %s", imageURL,
+				images[i].Error)
+			nbErrors++
+		}
+	}
+
+	err = db.WithTx(func(tx *sql.Tx) error {
+		for _, image := range images {
+			if err := image.Insert(tx); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		log.Printf("error: %v", err)
+		return
+	}
+
+	log.Printf("%d images cached, %d errors", len(images)-nbErrors,
+		nbErrors)
+}
+
+func CLICmdPostHistory(args []string, db *DB) {
+	// Options
+	cmdline := cmdline.New()
+
+	cmdline.AddArgument("id", "the identifier of the post")
+
+	cmdline.Parse(args)
+
+	id, err := strconv.ParseInt(cmdline.ArgumentValue("id"), 10, 64)
+	if err != nil {
+		log.Fatalf("invalid post id")
+	}
+
+	// Load the post and its revisions
+	var post Post
+	var revisions PostRevisionList
+
+	err = db.WithTx(func(tx *sql.Tx) error {
+		if err := post.LoadById(tx, id); err != nil {
+			return err
+		}
+
+		return revisions.LoadByPost(tx, post.Id)
+	})
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	fmt.Printf("post %d: %s\n", post.Id, post.Title)
+	fmt.Printf("%s\n", post.URL)
+	fmt.Printf("%d revisions\n", len(revisions))
+
+	// Print the changes between each version and the next one
+	versions := append(revisions, NewPostRevision(&post))
+
+	for i, r := range revisions {
+		next := "the current version"
+		if i < len(revisions)-1 {
+			next = fmt.Sprintf("revision %d", i+2)
+		}
+
+		fmt.Printf("\nrevision %d, replaced on %s by %s:\n", i+1,
+			r.ReplacedDate.Format("2006-01-02 15:04:05Z07:00"), next)
+		fmt.Print(r.Diff(versions[i+1]))
+	}
+}
+
+func CLICmdPrune(args []string, db *DB) {
+	// Options
+	cmdline := cmdline.New()
+
+	cmdline.AddOption("c", "content-after", "days",
+		"drop the content of posts older than a number of days")
+	cmdline.AddOption("d", "delete-after", "days",
+		"delete posts older than a number of days")
+	cmdline.AddFlag("n", "dry-run",
+		"report what would be pruned without modifying anything")
+
+	cmdline.Parse(args)
+
+	policy := NewPrunePolicy(
+		CLIDaysOptionValue(cmdline, "content-after"),
+		CLIDaysOptionValue(cmdline, "delete-after"),
+		time.Now().UTC())
+
+	if policy.IsEmpty() {
+		log.Fatalf("missing --content-after or --delete-after option")
+	}
+
+	// Prune posts
+	CLIPrune(db, policy, cmdline.IsOptionSet("dry-run"), true)
+}
+
+// CLIPrune prunes posts according to a policy. Since rebuilding the database
+// is slow and needs as much free space as the database itself, it is only
+// done when vacuum is set.
+func CLIPrune(db *DB, policy *PrunePolicy, dryRun, vacuum bool) {
+	sizeBefore, err := db.Size()
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	var stats *PruneStats
+	err = db.WithTx(func(tx *sql.Tx) error {
+		var err error
+
+		if dryRun {
+			stats, err = policy.Estimate(tx)
+		} else {
+			stats, err = policy.Apply(tx)
+		}
+
+		return err
+	})
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	if dryRun {
+		log.Printf("%d posts would be deleted, %d posts would lose "+
+			"their content, reclaiming about %d bytes of content",
+			stats.DeletedPosts, stats.ContentPosts, stats.ContentSize)
+		return
+	}
+
+	log.Printf("%d posts deleted, %d posts lost their content",
+		stats.DeletedPosts, stats.ContentPosts)
+
+	if !vacuum || (stats.DeletedPosts == 0 && stats.ContentPosts == 0) {
+		return
+	}
+
+	if err := db.Vacuum(); err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	sizeAfter, err := db.Size()
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	log.Printf("database size reduced from %d to %d bytes (%d bytes "+
+		"reclaimed)", sizeBefore, sizeAfter, sizeBefore-sizeAfter)
+}
+
+func CLICmdRejectedPosts(args []string, db *DB) {
+	// Options
+	cmdline := cmdline.New()
+	cmdline.Parse(args)
+
+	// Load rejected posts
+	var rejections PostRejectionList
+	if err := db.WithTx(rejections.LoadAll); err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	for _, r := range rejections {
+		fmt.Printf("%s  feed %d  %s\n",
+			r.Date.Format("2006-01-02 15:04:05Z07:00"), r.FeedId, r.URL)
+		fmt.Printf("    %s\n", r.Title)
+		fmt.Printf("    %s\n", r.Error)
+	}
+}
+
+func CLICmdSearch(args []string, db *DB) {
+	// Options
+	cmdline := cmdline.New()
+
+	cmdline.AddOption("n", "count", "count",
+		"the maximum number of results to print")
+	cmdline.SetOptionDefault("count", "10")
+	cmdline.AddFlag("", "fts5",
+		"interpret the query using the sqlite fts5 syntax")
+
+	cmdline.AddTrailingArguments("query", "the terms of the search query")
+
+	cmdline.Parse(args)
+
+	count, err := strconv.Atoi(cmdline.OptionValue("count"))
+	if err != nil || count < 1 {
+		log.Fatalf("invalid result count")
+	}
+
+	query := strings.Join(cmdline.TrailingArgumentsValues(), " ")
+
+	if cmdline.IsOptionSet("fts5") {
+		if strings.TrimSpace(query) == "" {
+			log.Fatalf("empty search query")
+		}
+	} else {
+		query, err = SearchQuery(query)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+
+	// Search posts
+	var results SearchResultList
+	err = db.WithTx(func(tx *sql.Tx) error {
+		return results.Search(tx, query, count, 0)
+	})
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	for i, r := range results {
+		if i > 0 {
+			fmt.Println()
+		}
+
+		fmt.Printf("%d  %s  %s\n", r.Post.Id,
+			r.Post.OrderDate().Format("2006-01-02"), r.Post.Title)
+		fmt.Printf("    %s\n", r.Post.URL)
+		fmt.Printf("    %s\n", r.HighlightedSnippet("[", "]"))
+	}
+}
+
+func CLICmdRebuildSearchIndex(args []string, db *DB) {
+	// Options
+	cmdline := cmdline.New()
+	cmdline.Parse(args)
+
+	// Rebuild the index
+	if err := db.WithTx(RebuildSearchIndex); err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	log.Printf("search index rebuilt")
+}
+
+// CLIDaysOptionValue returns the value of an option containing a number of
+// days, or 0 if the option is not set.
+func CLIDaysOptionValue(cmdline *cmdline.CmdLine, name string) int {
+	if !cmdline.IsOptionSet(name) {
+		return 0
+	}
+
+	days, err := strconv.Atoi(cmdline.OptionValue(name))
+	if err != nil || days < 1 {
+		log.Fatalf("invalid number of days for --%s", name)
+	}
+
+	return days
+}
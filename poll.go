@@ -0,0 +1,210 @@
+// Copyright (c) 2016 Nicolas Martyanoff <khaelin@gmail.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	MinPollInterval = time.Hour
+	MaxPollInterval = 24 * time.Hour
+
+	// The longest interval a publisher can ask for; feeds are polled at
+	// least this often whatever they announce.
+	MaxPublisherPollInterval = 7 * 24 * time.Hour
+)
+
+var cacheControlMaxAgeRE = regexp.MustCompile(`(?:^|[,\s])max-age=(\d+)`)
+
+// PollInterval returns the time to wait before polling the feed again. The
+// interval is derived from the posting frequency of the feed and bounded by
+// MinPollInterval and MaxPollInterval. It is never shorter than what the
+// publisher asked for in the feed itself or in the HTTP response, up to
+// MaxPublisherPollInterval.
+func (f *Feed) PollInterval(posts PostList) time.Duration {
+	interval := posts.PostingInterval() / 2
+
+	if interval < MinPollInterval {
+		interval = MinPollInterval
+	} else if interval > MaxPollInterval {
+		interval = MaxPollInterval
+	}
+
+	if hint := f.PublisherPollInterval(); hint > interval {
+		interval = hint
+	}
+
+	if interval > MaxPublisherPollInterval {
+		interval = MaxPublisherPollInterval
+	}
+
+	return interval
+}
+
+// PublisherPollInterval returns the longest interval requested by the
+// publisher of the feed, or 0 if there is none.
+func (f *Feed) PublisherPollInterval() time.Duration {
+	var interval time.Duration
+
+	hints := []time.Duration{
+		f.ttl,
+		f.SyndicationUpdatePeriod(),
+		HTTPCacheDuration(f.header),
+	}
+
+	for _, hint := range hints {
+		if hint > interval {
+			interval = hint
+		}
+	}
+
+	return interval
+}
+
+// SyndicationUpdatePeriod returns the update period announced with the
+// sy:updatePeriod and sy:updateFrequency elements of the RSS syndication
+// module, or 0 if the feed does not use them.
+func (f *Feed) SyndicationUpdatePeriod() time.Duration {
+	if f.feed == nil {
+		return 0
+	}
+
+	sy, found := f.feed.Extensions["sy"]
+	if !found {
+		return 0
+	}
+
+	var period time.Duration
+
+	if exts := sy["updatePeriod"]; len(exts) > 0 {
+		switch strings.TrimSpace(exts[0].Value) {
+		case "hourly":
+			period = time.Hour
+		case "daily":
+			period = 24 * time.Hour
+		case "weekly":
+			period = 7 * 24 * time.Hour
+		case "monthly":
+			period = 30 * 24 * time.Hour
+		case "yearly":
+			period = 365 * 24 * time.Hour
+		default:
+			return 0
+		}
+	} else {
+		return 0
+	}
+
+	if exts := sy["updateFrequency"]; len(exts) > 0 {
+		value := strings.TrimSpace(exts[0].Value)
+
+		frequency, err := strconv.Atoi(value)
+		if err == nil && frequency > 0 {
+			period /= time.Duration(frequency)
+		}
+	}
+
+	return period
+}
+
+// HTTPCacheDuration returns the time during which a response can be cached
+// according to its Cache-Control or Expires header fields, or 0 if the
+// response cannot be cached.
+func HTTPCacheDuration(header http.Header) time.Duration {
+	if header == nil {
+		return 0
+	}
+
+	if value := header.Get("Cache-Control"); value != "" {
+		value = strings.ToLower(value)
+
+		if strings.Contains(value, "no-cache") ||
+			strings.Contains(value, "no-store") {
+			return 0
+		}
+
+		if m := cacheControlMaxAgeRE.FindStringSubmatch(value); m != nil {
+			// Values too large to be parsed are capped as well.
+			maxSeconds := int64(MaxPublisherPollInterval / time.Second)
+
+			seconds, err := strconv.ParseInt(m[1], 10, 64)
+			if err != nil || seconds > maxSeconds {
+				seconds = maxSeconds
+			}
+
+			return time.Duration(seconds) * time.Second
+		}
+	}
+
+	if value := header.Get("Expires"); value != "" {
+		expires, err := http.ParseTime(value)
+		if err != nil {
+			return 0
+		}
+
+		now := time.Now()
+		if date, err := http.ParseTime(header.Get("Date")); err == nil {
+			now = date
+		}
+
+		if expires.After(now) {
+			return expires.Sub(now)
+		}
+	}
+
+	return 0
+}
+
+// PostingInterval returns the mean interval between the last posts of the
+// list, or MaxPollInterval if there are not enough posts to tell. If the last
+// post is older than the mean interval, the age of the last post is used
+// instead, so that feeds which stopped posting are polled less often.
+func (pl PostList) PostingInterval() time.Duration {
+	const maxPosts = 10
+
+	var dates []time.Time
+	for _, p := range pl {
+		if !p.Date.IsZero() {
+			dates = append(dates, p.Date)
+		}
+	}
+
+	if len(dates) < 2 {
+		return MaxPollInterval
+	}
+
+	sort.Slice(dates, func(i, j int) bool {
+		return dates[i].After(dates[j])
+	})
+
+	if len(dates) > maxPosts {
+		dates = dates[:maxPosts]
+	}
+
+	span := dates[0].Sub(dates[len(dates)-1])
+	interval := span / time.Duration(len(dates)-1)
+
+	if age := time.Since(dates[0]); age > interval {
+		interval = age
+	}
+
+	return interval
+}
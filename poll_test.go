@@ -0,0 +1,112 @@
+// Copyright (c) 2016 Nicolas Martyanoff <khaelin@gmail.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestHTTPCacheDuration(t *testing.T) {
+	date := "Mon, 19 Oct 2026 10:00:00 GMT"
+
+	tests := []struct {
+		header   http.Header
+		duration time.Duration
+	}{
+		{nil, 0},
+		{http.Header{}, 0},
+
+		// Cache-Control
+		{http.Header{"Cache-Control": {"max-age=3600"}}, time.Hour},
+		{http.Header{"Cache-Control": {"public, Max-Age=60"}},
+			time.Minute},
+		{http.Header{"Cache-Control": {"s-maxage=60"}}, 0},
+		{http.Header{"Cache-Control": {"no-cache, max-age=60"}}, 0},
+		{http.Header{"Cache-Control": {"no-store"}}, 0},
+		{http.Header{"Cache-Control": {"max-age=31536000"}},
+			MaxPublisherPollInterval},
+		{http.Header{"Cache-Control": {"max-age=9223372036854775807"}},
+			MaxPublisherPollInterval},
+		{http.Header{"Cache-Control": {"max-age=99999999999999999999"}},
+			MaxPublisherPollInterval},
+
+		// Expires
+		{http.Header{"Date": {date},
+			"Expires": {"Mon, 19 Oct 2026 12:00:00 GMT"}},
+			2 * time.Hour},
+		{http.Header{"Date": {date},
+			"Expires": {"Mon, 19 Oct 2026 09:00:00 GMT"}}, 0},
+		{http.Header{"Date": {date}, "Expires": {"0"}}, 0},
+		{http.Header{"Date": {date},
+			"Cache-Control": {"max-age=60"},
+			"Expires":       {"Mon, 19 Oct 2026 12:00:00 GMT"}},
+			time.Minute},
+	}
+
+	for _, test := range tests {
+		duration := HTTPCacheDuration(test.header)
+		if duration != test.duration {
+			t.Errorf("%v: got %v, expected %v",
+				test.header, duration, test.duration)
+		}
+	}
+}
+
+func TestPollInterval(t *testing.T) {
+	now := time.Now().UTC()
+
+	postsEvery := func(interval time.Duration, n int) PostList {
+		var posts PostList
+		for i := 0; i < n; i++ {
+			date := now.Add(-time.Duration(i) * interval)
+			posts = append(posts, &Post{Date: date})
+		}
+		return posts
+	}
+
+	tests := []struct {
+		name     string
+		feed     *Feed
+		posts    PostList
+		interval time.Duration
+	}{
+		{"no post", &Feed{}, nil, MaxPollInterval / 2},
+		{"frequent posts", &Feed{}, postsEvery(10*time.Minute, 10),
+			MinPollInterval},
+		{"daily posts", &Feed{}, postsEvery(24*time.Hour, 10),
+			12 * time.Hour},
+		{"rare posts", &Feed{}, postsEvery(30*24*time.Hour, 10),
+			MaxPollInterval},
+		{"ttl", &Feed{ttl: 2 * 24 * time.Hour},
+			postsEvery(10*time.Minute, 10), 2 * 24 * time.Hour},
+		{"short ttl", &Feed{ttl: time.Minute},
+			postsEvery(24*time.Hour, 10), 12 * time.Hour},
+		{"long ttl", &Feed{ttl: 365 * 24 * time.Hour}, nil,
+			MaxPublisherPollInterval},
+		{"long cache duration", &Feed{header: http.Header{
+			"Cache-Control": {"max-age=31536000"}}}, nil,
+			MaxPublisherPollInterval},
+	}
+
+	for _, test := range tests {
+		interval := test.feed.PollInterval(test.posts)
+		if interval != test.interval {
+			t.Errorf("%s: got %v, expected %v",
+				test.name, interval, test.interval)
+		}
+	}
+}
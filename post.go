@@ -17,6 +17,8 @@ package main
 import (
 	"database/sql"
 	"fmt"
+	"html"
+	"strconv"
 	"time"
 
 	"github.com/mmcdole/gofeed"
@@ -32,10 +34,50 @@ type Post struct {
 	Author  string
 	Content string
 	Enabled bool
+
+	FirstSeen   time.Time // when the post was imported
+	LastChanged time.Time // when the post was last modified upstream
+
+	// The content of old posts can be dropped to save space; their
+	// metadata are kept for archives.
+	ContentPruned bool
+
+	// The filter which disabled the post, if any (see FeedFilterList.Apply)
+	FilteredBy string
+
+	// Set when the post was hidden or unhidden manually, in which case
+	// Enabled is not modified by filters anymore.
+	Moderated bool
+
+	// Pinned posts are displayed first until this date.
+	PinnedUntil time.Time
+
+	// Computed from the content when the post is imported, see
+	// Post.UpdateReadingTime.
+	WordCount   int
+	ReadingTime time.Duration
+
+	Categories []string // not stored
+	Tags       []string // normalized categories, see PostList.LoadTags
+
+	Enclosures EnclosureList // see PostList.LoadEnclosures
+
+	// Set when the date of the post was in the future and was replaced by
+	// the date the feed was fetched.
+	dateClamped bool
+
+	// Set by PostList.Diff when the post was modified upstream.
+	modified bool
 }
 
 type PostList []*Post
 
+// Publication dates older than minPostDate, or later than the date a post
+// was first seen by more than maxPostDateAdvance, are not trusted.
+var minPostDate = time.Date(1990, 1, 1, 0, 0, 0, 0, time.UTC)
+
+const maxPostDateAdvance = 24 * time.Hour
+
 func (p *Post) Key() string {
 	if p.GUID != "" {
 		return p.GUID
@@ -44,20 +86,54 @@ func (p *Post) Key() string {
 	return p.URL
 }
 
-func (p *Post) Insert(tx *sql.Tx) error {
-	var date int64
-	if p.Date.IsZero() {
-		date = 0
-	} else {
-		date = p.Date.UTC().Unix()
+// AuthorName returns the name to display as the author of the post, falling
+// back to the author or title of its feed.
+func (p *Post) AuthorName(feed *Feed) string {
+	if p.Author != "" {
+		return p.Author
+	} else if feed.Author != "" {
+		return feed.Author
+	}
+
+	return feed.Title
+}
+
+// OrderDate returns the date used to order the post: its publication date,
+// or the date it was first seen if the publication date is missing, older
+// than 1990 or later than the date it was first seen.
+func (p *Post) OrderDate() time.Time {
+	if p.Date.Before(minPostDate) || (!p.FirstSeen.IsZero() &&
+		p.Date.After(p.FirstSeen.Add(maxPostDateAdvance))) {
+		return p.FirstSeen
+	}
+
+	return p.Date
+}
+
+// PagePath returns the path of the page of the post in the website.
+func (p *Post) PagePath() string {
+	name := fmt.Sprintf("%d", p.Id)
+	if slug := Slug(p.Title, 60); slug != "" {
+		name += "-" + slug
 	}
 
+	return "posts/" + name + ".html"
+}
+
+func (p *Post) Insert(tx *sql.Tx) error {
 	res, err := tx.Exec(
 		`INSERT INTO posts (guid, url, feed, date, title, author,
-		                    content, enabled)
-		   VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
-		p.GUID, p.URL, p.FeedId, date, p.Title, p.Author,
-		p.Content, p.Enabled)
+		                    content, enabled, content_pruned,
+		                    first_seen, last_changed, canonical_url,
+		                    filtered_by, moderated, pinned_until,
+		                    word_count, reading_time)
+		   VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		p.GUID, p.URL, p.FeedId, Timestamp(p.Date), p.Title, p.Author,
+		p.Content, p.Enabled, p.ContentPruned,
+		Timestamp(p.FirstSeen), Timestamp(p.LastChanged),
+		CanonicalURL(p.URL), p.FilteredBy, p.Moderated,
+		Timestamp(p.PinnedUntil), p.WordCount,
+		int64(p.ReadingTime/time.Second))
 	if err != nil {
 		return fmt.Errorf("cannot insert post: %v", err)
 	}
@@ -68,17 +144,11 @@ func (p *Post) Insert(tx *sql.Tx) error {
 	}
 
 	p.Id = id
-	return nil
+
+	return p.UpdateSearchIndex(tx)
 }
 
 func (p *Post) Update(tx *sql.Tx) error {
-	var date int64
-	if p.Date.IsZero() {
-		date = 0
-	} else {
-		date = p.Date.UTC().Unix()
-	}
-
 	_, err := tx.Exec(
 		`UPDATE posts SET
 		     guid = ?,
@@ -88,14 +158,75 @@ func (p *Post) Update(tx *sql.Tx) error {
 		     title = ?,
 		     author = ?,
 		     content = ?,
-		     enabled = ?
+		     enabled = ?,
+		     content_pruned = ?,
+		     first_seen = ?,
+		     last_changed = ?,
+		     canonical_url = ?,
+		     filtered_by = ?,
+		     moderated = ?,
+		     pinned_until = ?,
+		     word_count = ?,
+		     reading_time = ?
 		   WHERE id = ?`,
-		p.GUID, p.URL, p.FeedId, date, p.Title, p.Author,
-		p.Content, p.Enabled, p.Id)
+		p.GUID, p.URL, p.FeedId, Timestamp(p.Date), p.Title, p.Author,
+		p.Content, p.Enabled, p.ContentPruned,
+		Timestamp(p.FirstSeen), Timestamp(p.LastChanged),
+		CanonicalURL(p.URL), p.FilteredBy, p.Moderated,
+		Timestamp(p.PinnedUntil), p.WordCount,
+		int64(p.ReadingTime/time.Second), p.Id)
 	if err != nil {
 		return fmt.Errorf("cannot update post: %v", err)
 	}
 
+	return p.UpdateSearchIndex(tx)
+}
+
+// Delete removes the post and remembers its key so that it is not imported
+// again.
+func (p *Post) Delete(tx *sql.Tx) error {
+	if err := p.DeleteSearchIndex(tx); err != nil {
+		return err
+	}
+
+	if err := p.DeleteRevisions(tx); err != nil {
+		return err
+	}
+
+	if err := p.DeleteTags(tx); err != nil {
+		return err
+	}
+
+	if err := p.DeleteEnclosures(tx); err != nil {
+		return err
+	}
+
+	if err := p.DeleteDuplicates(tx); err != nil {
+		return err
+	}
+
+	if err := p.Ignore(tx); err != nil {
+		return err
+	}
+
+	_, err := tx.Exec(`DELETE FROM posts WHERE id = ?`, p.Id)
+	if err != nil {
+		return fmt.Errorf("cannot delete post: %v", err)
+	}
+
+	return nil
+}
+
+// Ignore records the key of the post in deleted_posts so that it is ignored
+// the next time its feed is updated.
+func (p *Post) Ignore(tx *sql.Tx) error {
+	_, err := tx.Exec(
+		`INSERT OR IGNORE INTO deleted_posts (feed, key)
+		   VALUES (?, ?)`, p.FeedId, p.Key())
+	if err != nil {
+		return fmt.Errorf("cannot record deleted post: %v", err)
+	}
+
 	return nil
 }
 
@@ -117,34 +248,151 @@ func (p *Post) ReadFromGofeedItem(item *gofeed.Item) {
 
 	if item.Content != "" {
 		p.Content = item.Content
-	} else {
+	} else if item.Description != "" {
 		p.Content = item.Description
+	} else if item.ITunesExt != nil {
+		// Podcast episodes are often only described by iTunes
+		// elements.
+		p.Content = html.EscapeString(item.ITunesExt.Summary)
 	}
+
+	p.Enclosures = ReadEnclosures(item)
+
+	p.UpdateReadingTime()
+
+	p.Categories = item.Categories
 }
 
-func (p *Post) ReadFromRow(row *sql.Rows) error {
-	var date int64
+// The columns read by Post.ReadFromRow, for the posts table aliased as p.
+const postColumns = `p.id, p.guid, p.url, p.feed, p.date, p.title, p.author,
+                     p.content, p.enabled, p.content_pruned, p.first_seen,
+                     p.last_changed, p.filtered_by, p.moderated,
+                     p.pinned_until, p.word_count, p.reading_time`
+
+// The date used to order posts, for the posts table aliased as p. It must
+// match Post.OrderDate.
+var postOrderDate = fmt.Sprintf(`(CASE WHEN p.date < %d OR
+                                          (p.first_seen > 0 AND
+                                           p.date > p.first_seen + %d)
+                                     THEN p.first_seen
+                                     ELSE p.date
+                                END)`,
+	minPostDate.Unix(), int64(maxPostDateAdvance/time.Second))
+
+// ReadFromRow reads the columns listed in postColumns. Additional
+// destinations are scanned after these columns.
+func (p *Post) ReadFromRow(row *sql.Rows, dest ...interface{}) error {
+	var date, firstSeen, lastChanged, pinnedUntil, readingTime int64
+
+	values := []interface{}{&p.Id, &p.GUID, &p.URL, &p.FeedId, &date,
+		&p.Title, &p.Author, &p.Content, &p.Enabled, &p.ContentPruned,
+		&firstSeen, &lastChanged, &p.FilteredBy, &p.Moderated,
+		&pinnedUntil, &p.WordCount, &readingTime}
+
+	if err := row.Scan(append(values, dest...)...); err != nil {
+		return err
+	}
 
-	err := row.Scan(&p.Id, &p.GUID, &p.URL, &p.FeedId, &date, &p.Title,
-		&p.Author, &p.Content, &p.Enabled)
+	p.Date = TimestampTime(date)
+	p.FirstSeen = TimestampTime(firstSeen)
+	p.LastChanged = TimestampTime(lastChanged)
+	p.PinnedUntil = TimestampTime(pinnedUntil)
+	p.ReadingTime = time.Duration(readingTime) * time.Second
+
+	return nil
+}
+
+func (p *Post) LoadById(tx *sql.Tx, id int64) error {
+	var posts PostList
+	err := posts.Load(tx,
+		`SELECT `+postColumns+`
+		   FROM posts AS p
+		   WHERE p.id = ?`, id)
+	if err != nil {
+		return err
+	}
+
+	if len(posts) == 0 {
+		return fmt.Errorf("unknown post %d", id)
+	}
+
+	*p = *posts[0]
+	return nil
+}
+
+// LoadByIdOrURL loads a post from its identifier or from its URL.
+func (p *Post) LoadByIdOrURL(tx *sql.Tx, s string) error {
+	if id, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return p.LoadById(tx, id)
+	}
+
+	var posts PostList
+	err := posts.Load(tx,
+		`SELECT `+postColumns+`
+		   FROM posts AS p
+		   WHERE p.url = ? OR p.canonical_url = ?
+		   LIMIT 1`, s, CanonicalURL(s))
 	if err != nil {
 		return err
 	}
 
-	p.Date = time.Unix(date, 0).UTC()
+	if len(posts) == 0 {
+		return fmt.Errorf("unknown post %s", s)
+	}
 
+	*p = *posts[0]
 	return nil
 }
 
+// IsPinned returns true if the post is pinned at a given date.
+func (p *Post) IsPinned(now time.Time) bool {
+	return now.Before(p.PinnedUntil)
+}
+
+// LoadRange loads a page of posts; currently pinned posts come first.
 func (pl *PostList) LoadRange(tx *sql.Tx, count int, offset int) error {
-	rows, err := tx.Query(
-		`SELECT p.id, p.guid, p.url, p.feed, p.date, p.title, p.author,
-		        p.content, p.enabled
+	return pl.Load(tx,
+		`SELECT `+postColumns+`
+		   FROM posts AS p
+		   INNER JOIN feeds AS f ON f.id = p.feed
+		   WHERE f.enabled = 1 AND p.enabled = 1
+		   ORDER BY p.pinned_until > ? DESC, `+postOrderDate+` DESC
+		   LIMIT ? OFFSET ?`, Timestamp(time.Now()), count, offset)
+}
+
+func (pl *PostList) LoadEnabled(tx *sql.Tx) error {
+	return pl.Load(tx,
+		`SELECT `+postColumns+`
 		   FROM posts AS p
 		   INNER JOIN feeds AS f ON f.id = p.feed
 		   WHERE f.enabled = 1 AND p.enabled = 1
-		   ORDER BY date DESC
-		   LIMIT ? OFFSET ?`, count, offset)
+		   ORDER BY `+postOrderDate+` DESC`)
+}
+
+func (pl *PostList) LoadAll(tx *sql.Tx) error {
+	return pl.Load(tx,
+		`SELECT `+postColumns+`
+		   FROM posts AS p`)
+}
+
+func (pl *PostList) LoadFiltered(tx *sql.Tx) error {
+	return pl.Load(tx,
+		`SELECT `+postColumns+`
+		   FROM posts AS p
+		   WHERE p.filtered_by <> ''
+		   ORDER BY p.feed, `+postOrderDate+` DESC`)
+}
+
+func (pl *PostList) LoadByFeed(tx *sql.Tx, feedId int64) error {
+	return pl.Load(tx,
+		`SELECT `+postColumns+`
+		   FROM posts AS p
+		   WHERE p.feed = ?`, feedId)
+}
+
+// Load appends the posts selected by a query returning postColumns.
+func (pl *PostList) Load(tx *sql.Tx, query string, args ...interface{}) error {
+	rows, err := tx.Query(query, args...)
 	if err != nil {
 		return fmt.Errorf("cannot load posts: %v", err)
 	}
@@ -166,35 +414,62 @@ func (pl *PostList) LoadRange(tx *sql.Tx, count int, offset int) error {
 	return nil
 }
 
-func (pl *PostList) LoadByFeed(tx *sql.Tx, feedId int64) error {
-	rows, err := tx.Query(
-		`SELECT id, guid, url, feed, date, title, author, content,
-		        enabled
-		   FROM posts
-		   WHERE feed = ?`, feedId)
+// DeleteByFeed deletes all the posts of a feed and the data associated with
+// them, including the keys of deleted posts and rejections.
+func (pl *PostList) DeleteByFeed(tx *sql.Tx, feedId int64) error {
+	_, err := tx.Exec(
+		`DELETE FROM posts_fts
+		   WHERE rowid IN (SELECT id FROM posts WHERE feed = ?)`,
+		feedId)
 	if err != nil {
-		return fmt.Errorf("cannot load posts: %v", err)
+		return fmt.Errorf("cannot delete posts from search index: %v",
+			err)
 	}
-	defer rows.Close()
 
-	for rows.Next() {
-		p := &Post{}
-		if err := p.ReadFromRow(rows); err != nil {
-			return fmt.Errorf("invalid post: %v", err)
-		}
+	_, err = tx.Exec(
+		`DELETE FROM post_revisions
+		   WHERE post IN (SELECT id FROM posts WHERE feed = ?)`,
+		feedId)
+	if err != nil {
+		return fmt.Errorf("cannot delete post revisions: %v", err)
+	}
 
-		*pl = append(*pl, p)
+	_, err = tx.Exec(
+		`DELETE FROM post_tags
+		   WHERE post IN (SELECT id FROM posts WHERE feed = ?)`,
+		feedId)
+	if err != nil {
+		return fmt.Errorf("cannot delete post tags: %v", err)
 	}
 
-	if err := rows.Err(); err != nil {
-		return fmt.Errorf("cannot load posts: %v", err)
+	_, err = tx.Exec(
+		`DELETE FROM post_enclosures
+		   WHERE post IN (SELECT id FROM posts WHERE feed = ?)`,
+		feedId)
+	if err != nil {
+		return fmt.Errorf("cannot delete post enclosures: %v", err)
 	}
 
-	return nil
-}
+	_, err = tx.Exec(`DELETE FROM post_rejections WHERE feed = ?`, feedId)
+	if err != nil {
+		return fmt.Errorf("cannot delete post rejections: %v", err)
+	}
 
-func (pl *PostList) DeleteByFeed(tx *sql.Tx, feedId int64) error {
-	_, err := tx.Exec(`DELETE FROM posts WHERE feed = ?`, feedId)
+	_, err = tx.Exec(
+		`DELETE FROM duplicate_posts
+		   WHERE feed = ?
+		      OR original IN (SELECT id FROM posts WHERE feed = ?)`,
+		feedId, feedId)
+	if err != nil {
+		return fmt.Errorf("cannot delete duplicate posts: %v", err)
+	}
+
+	_, err = tx.Exec(`DELETE FROM deleted_posts WHERE feed = ?`, feedId)
+	if err != nil {
+		return fmt.Errorf("cannot delete posts: %v", err)
+	}
+
+	_, err = tx.Exec(`DELETE FROM posts WHERE feed = ?`, feedId)
 	if err != nil {
 		return fmt.Errorf("cannot delete posts: %v", err)
 	}
@@ -202,16 +477,55 @@ func (pl *PostList) DeleteByFeed(tx *sql.Tx, feedId int64) error {
 	return nil
 }
 
+// Merge returns the posts of the list followed by the posts of newPosts
+// which are not already in the list.
+func (pl PostList) Merge(newPosts PostList) PostList {
+	keys := make(map[string]bool)
+	for _, p := range pl {
+		keys[p.Key()] = true
+	}
+
+	posts := append(PostList(nil), pl...)
+
+	for _, p := range newPosts {
+		if !keys[p.Key()] {
+			keys[p.Key()] = true
+			posts = append(posts, p)
+		}
+	}
+
+	return posts
+}
+
 func (pl *PostList) Diff(newPosts PostList) (PostList, PostList) {
 	table := make(map[string]*Post)
+	urls := make(map[string]*Post)
 	for _, p := range *pl {
 		table[p.Key()] = p
+		urls[p.URL] = p
+	}
+
+	newKeys := make(map[string]bool)
+	for _, newPost := range newPosts {
+		newKeys[newPost.Key()] = true
 	}
 
 	var new, updated PostList
 
 	for _, newPost := range newPosts {
 		p, found := table[newPost.Key()]
+		if !found {
+			// A post published again with a new identifier but
+			// the same URL is the same post, as long as its
+			// previous identifier is not used anymore.
+			p, found = urls[newPost.URL]
+			if found && newKeys[p.Key()] {
+				found = false
+			} else if found {
+				delete(urls, newPost.URL)
+			}
+		}
+
 		if !found {
 			// Add new post
 			new = append(new, newPost)
@@ -219,22 +533,54 @@ func (pl *PostList) Diff(newPosts PostList) (PostList, PostList) {
 		}
 
 		// Update existing post
-		diff := false
-		diff = diff || (p.GUID != newPost.GUID)
-		diff = diff || (p.URL != newPost.URL)
-		diff = diff || (p.Date != newPost.Date)
-		diff = diff || (p.Title != newPost.Title)
-		diff = diff || (p.Author != newPost.Author)
-		diff = diff || (p.Content != newPost.Content)
+		modified := false
+		modified = modified || (p.GUID != newPost.GUID)
+		modified = modified || (p.URL != newPost.URL)
+		if !newPost.dateClamped {
+			// A clamped date changes every time the feed is
+			// fetched; we keep the first one.
+			modified = modified || (p.Date != newPost.Date)
+		}
+		modified = modified || (p.Title != newPost.Title)
+		modified = modified || (p.Author != newPost.Author)
+		if !p.ContentPruned {
+			modified = modified || (p.Content != newPost.Content)
+		}
 
 		p.GUID = newPost.GUID
 		p.URL = newPost.URL
-		p.Date = newPost.Date
+		if !newPost.dateClamped {
+			p.Date = newPost.Date
+		}
 		p.Title = newPost.Title
 		p.Author = newPost.Author
-		p.Content = newPost.Content
+		if !p.ContentPruned {
+			p.Content = newPost.Content
+			p.WordCount = newPost.WordCount
+			p.ReadingTime = newPost.ReadingTime
+		}
+		p.Categories = newPost.Categories
+		p.modified = modified
+
+		tagsChanged := !EqualTags(p.Tags, newPost.Tags)
+		p.Tags = newPost.Tags
+
+		enclosuresChanged := !EqualEnclosures(p.Enclosures,
+			newPost.Enclosures)
+		p.Enclosures = newPost.Enclosures
+
+		// Filters are evaluated again each time since they may have
+		// changed. Manual moderation has priority over filters.
+		filterChanged := p.FilteredBy != newPost.FilteredBy
+		if filterChanged {
+			p.FilteredBy = newPost.FilteredBy
+			if !p.Moderated {
+				p.Enabled = newPost.Enabled
+			}
+		}
 
-		if diff {
+		if modified || filterChanged || tagsChanged ||
+			enclosuresChanged {
 			updated = append(updated, p)
 		}
 	}
@@ -242,6 +588,34 @@ func (pl *PostList) Diff(newPosts PostList) (PostList, PostList) {
 	return new, updated
 }
 
+// LoadDeletedPostKeys returns the set of the keys of the posts deleted from
+// a feed.
+func LoadDeletedPostKeys(tx *sql.Tx, feedId int64) (map[string]bool, error) {
+	rows, err := tx.Query(
+		`SELECT key FROM deleted_posts WHERE feed = ?`, feedId)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load deleted posts: %v", err)
+	}
+	defer rows.Close()
+
+	keys := make(map[string]bool)
+
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, fmt.Errorf("invalid deleted post: %v", err)
+		}
+
+		keys[key] = true
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("cannot load deleted posts: %v", err)
+	}
+
+	return keys, nil
+}
+
 func CountPosts(tx *sql.Tx) (int, error) {
 	row := tx.QueryRow(
 		`SELECT count(*)
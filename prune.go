@@ -0,0 +1,157 @@
+// Copyright (c) 2016 Nicolas Martyanoff <khaelin@gmail.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// A PrunePolicy describes which old posts are pruned, based on the date used
+// to order them (see Post.OrderDate), i.e. the date they were first seen if
+// they are not dated. Posts published before ContentDate lose their content
+// and their revisions but keep their metadata; posts published before
+// DeleteDate are deleted. Zero dates disable the corresponding action.
+type PrunePolicy struct {
+	ContentDate time.Time
+	DeleteDate  time.Time
+}
+
+// Undated posts imported before first seen dates were recorded have no order
+// date; their age is unknown and they are never pruned.
+var prunedOrderDate = `(CASE WHEN ` + postOrderDate + ` > 0
+                             THEN ` + postOrderDate + `
+                        END)`
+
+type PruneStats struct {
+	ContentPosts int   // posts whose content was dropped
+	DeletedPosts int   // posts deleted
+	ContentSize  int64 // size of the content removed, in bytes
+}
+
+func NewPrunePolicy(contentDays, deleteDays int, now time.Time) *PrunePolicy {
+	var policy PrunePolicy
+
+	if contentDays > 0 {
+		policy.ContentDate = now.AddDate(0, 0, -contentDays)
+	}
+
+	if deleteDays > 0 {
+		policy.DeleteDate = now.AddDate(0, 0, -deleteDays)
+	}
+
+	return &policy
+}
+
+func (policy *PrunePolicy) IsEmpty() bool {
+	return policy.ContentDate.IsZero() && policy.DeleteDate.IsZero()
+}
+
+// Estimate computes what Apply would remove without modifying anything.
+func (policy *PrunePolicy) Estimate(tx *sql.Tx) (*PruneStats, error) {
+	var stats PruneStats
+
+	if !policy.DeleteDate.IsZero() {
+		row := tx.QueryRow(
+			`SELECT count(*), coalesce(sum(length(p.content)), 0)
+			   FROM posts AS p
+			   WHERE `+prunedOrderDate+` < ?`,
+			Timestamp(policy.DeleteDate))
+
+		err := row.Scan(&stats.DeletedPosts, &stats.ContentSize)
+		if err != nil {
+			return nil, fmt.Errorf("cannot count posts: %v", err)
+		}
+	}
+
+	if !policy.ContentDate.IsZero() {
+		var count int
+		var size int64
+
+		row := tx.QueryRow(
+			`SELECT count(*), coalesce(sum(length(p.content)), 0)
+			   FROM posts AS p
+			   WHERE `+prunedOrderDate+` < ?
+			     AND `+prunedOrderDate+` >= ?
+			     AND p.content_pruned = 0`,
+			Timestamp(policy.ContentDate), Timestamp(policy.DeleteDate))
+
+		if err := row.Scan(&count, &size); err != nil {
+			return nil, fmt.Errorf("cannot count posts: %v", err)
+		}
+
+		stats.ContentPosts = count
+		stats.ContentSize += size
+	}
+
+	return &stats, nil
+}
+
+// Apply prunes posts according to the policy. Deleted posts are remembered
+// so that they are not imported again if they are still present in their
+// feed.
+func (policy *PrunePolicy) Apply(tx *sql.Tx) (*PruneStats, error) {
+	stats, err := policy.Estimate(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	if !policy.DeleteDate.IsZero() {
+		var posts PostList
+		err := posts.Load(tx,
+			`SELECT `+postColumns+`
+			   FROM posts AS p
+			   WHERE `+prunedOrderDate+` < ?`,
+			Timestamp(policy.DeleteDate))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, p := range posts {
+			if err := p.Delete(tx); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if !policy.ContentDate.IsZero() {
+		var posts PostList
+		err := posts.Load(tx,
+			`SELECT `+postColumns+`
+			   FROM posts AS p
+			   WHERE `+prunedOrderDate+` < ?
+			     AND p.content_pruned = 0`,
+			Timestamp(policy.ContentDate))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, p := range posts {
+			if err := p.DeleteRevisions(tx); err != nil {
+				return nil, err
+			}
+
+			p.Content = ""
+			p.ContentPruned = true
+
+			if err := p.Update(tx); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return stats, nil
+}
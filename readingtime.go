@@ -0,0 +1,143 @@
+// Copyright (c) 2016 Nicolas Martyanoff <khaelin@gmail.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+const (
+	WordsPerMinute = 200
+
+	// Code is read more slowly than prose and cannot be measured in
+	// words, so each code block adds a fixed reading time.
+	CodeBlockReadingTime = 30 * time.Second
+)
+
+// CountWords returns the number of words of the text content of an HTML
+// fragment, code blocks excluded, and the number of code blocks.
+func CountWords(content string) (int, int) {
+	var buf bytes.Buffer
+
+	nbCodeBlocks := 0
+	codeDepth := 0
+	skip := 0
+
+	z := html.NewTokenizer(strings.NewReader(content))
+
+	for {
+		tt := z.Next()
+
+		switch tt {
+		case html.ErrorToken:
+			return len(strings.Fields(buf.String())), nbCodeBlocks
+
+		case html.StartTagToken, html.EndTagToken:
+			name, _ := z.TagName()
+			tag := string(name)
+			start := tt == html.StartTagToken
+
+			switch tag {
+			case "pre":
+				if start {
+					if codeDepth == 0 {
+						nbCodeBlocks++
+					}
+					codeDepth++
+				} else if codeDepth > 0 {
+					codeDepth--
+				}
+
+			case "script", "style":
+				if start {
+					skip++
+				} else if skip > 0 {
+					skip--
+				}
+			}
+
+			if isBlockElement(tag) {
+				buf.WriteByte(' ')
+			}
+
+		case html.SelfClosingTagToken:
+			name, _ := z.TagName()
+			if isBlockElement(string(name)) {
+				buf.WriteByte(' ')
+			}
+
+		case html.TextToken:
+			if codeDepth == 0 && skip == 0 {
+				buf.Write(z.Text())
+			}
+		}
+	}
+}
+
+// ReadingTime returns the estimated time needed to read a text.
+func ReadingTime(nbWords, nbCodeBlocks int) time.Duration {
+	d := time.Duration(nbWords) * time.Minute / WordsPerMinute
+	d += time.Duration(nbCodeBlocks) * CodeBlockReadingTime
+
+	return d.Round(time.Second)
+}
+
+// UpdateReadingTime computes the word count and the reading time of the
+// post from its content.
+func (p *Post) UpdateReadingTime() {
+	nbWords, nbCodeBlocks := CountWords(p.Content)
+
+	p.WordCount = nbWords
+	p.ReadingTime = ReadingTime(nbWords, nbCodeBlocks)
+}
+
+// ReadingMinutes returns the reading time of the post rounded up to the
+// next minute.
+func (p *Post) ReadingMinutes() int {
+	minutes := int((p.ReadingTime + time.Minute - 1) / time.Minute)
+	if minutes < 1 {
+		minutes = 1
+	}
+
+	return minutes
+}
+
+// UpdateReadingTimes computes the word count and the reading time of all
+// posts whose content was not pruned.
+func UpdateReadingTimes(tx *sql.Tx) error {
+	var posts PostList
+	if err := posts.LoadAll(tx); err != nil {
+		return err
+	}
+
+	for _, p := range posts {
+		if p.ContentPruned {
+			continue
+		}
+
+		p.UpdateReadingTime()
+
+		if err := p.Update(tx); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
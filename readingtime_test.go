@@ -0,0 +1,45 @@
+// Copyright (c) 2016 Nicolas Martyanoff <khaelin@gmail.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package main
+
+import "testing"
+
+func TestCountWords(t *testing.T) {
+	tests := []struct {
+		content      string
+		nbWords      int
+		nbCodeBlocks int
+	}{
+		{"", 0, 0},
+		{"<p>Hello, world!</p>", 2, 0},
+		{"<p>one two</p><p>three</p>", 3, 0},
+		{"one<br>two", 2, 0},
+		{"<ul><li>a</li><li>b c</li></ul>", 3, 0},
+		{"<p>a</p><pre>code here\nmore</pre><pre><code>x</code></pre>",
+			1, 2},
+		{"<pre><pre>x</pre></pre>", 0, 1},
+		{"<p>x</p><script>var a = 1;</script><style>p {}</style>", 1, 0},
+	}
+
+	for _, test := range tests {
+		nbWords, nbCodeBlocks := CountWords(test.content)
+		if nbWords != test.nbWords || nbCodeBlocks != test.nbCodeBlocks {
+			t.Errorf("%q: got %d words and %d code blocks, expected "+
+				"%d words and %d code blocks", test.content,
+				nbWords, nbCodeBlocks, test.nbWords,
+				test.nbCodeBlocks)
+		}
+	}
+}
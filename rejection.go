@@ -0,0 +1,101 @@
+// Copyright (c) 2016 Nicolas Martyanoff <khaelin@gmail.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// A PostRejection records a post which could not be stored during the last
+// update of its feed.
+type PostRejection struct {
+	FeedId int64
+	Key    string
+	URL    string
+	Title  string
+	Error  string
+	Date   time.Time
+}
+
+type PostRejectionList []*PostRejection
+
+// RejectPost records the error which prevented a post from being stored,
+// replacing any previous rejection of the same post.
+func RejectPost(tx *sql.Tx, p *Post, perr error, now time.Time) error {
+	_, err := tx.Exec(
+		`INSERT OR REPLACE INTO post_rejections (feed, key, url, title,
+		                                         error, date)
+		   VALUES (?, ?, ?, ?, ?, ?)`,
+		p.FeedId, p.Key(), p.URL, p.Title, perr.Error(), Timestamp(now))
+	if err != nil {
+		return fmt.Errorf("cannot record post rejection: %v", err)
+	}
+
+	return nil
+}
+
+// ClearPostRejection removes the rejection of a post which was stored
+// successfully.
+func ClearPostRejection(tx *sql.Tx, p *Post) error {
+	_, err := tx.Exec(
+		`DELETE FROM post_rejections WHERE feed = ? AND key = ?`,
+		p.FeedId, p.Key())
+	if err != nil {
+		return fmt.Errorf("cannot delete post rejection: %v", err)
+	}
+
+	return nil
+}
+
+func (r *PostRejection) ReadFromRow(row *sql.Rows) error {
+	var date int64
+
+	err := row.Scan(&r.FeedId, &r.Key, &r.URL, &r.Title, &r.Error, &date)
+	if err != nil {
+		return err
+	}
+
+	r.Date = TimestampTime(date)
+
+	return nil
+}
+
+func (rl *PostRejectionList) LoadAll(tx *sql.Tx) error {
+	rows, err := tx.Query(
+		`SELECT feed, key, url, title, error, date
+		   FROM post_rejections
+		   ORDER BY date DESC`)
+	if err != nil {
+		return fmt.Errorf("cannot load post rejections: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		r := &PostRejection{}
+		if err := r.ReadFromRow(rows); err != nil {
+			return fmt.Errorf("invalid post rejection: %v", err)
+		}
+
+		*rl = append(*rl, r)
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("cannot load post rejections: %v", err)
+	}
+
+	return nil
+}
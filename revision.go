@@ -0,0 +1,159 @@
+// Copyright (c) 2016 Nicolas Martyanoff <khaelin@gmail.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// A PostRevision is a previous version of a post, saved when the post was
+// modified upstream.
+type PostRevision struct {
+	Id           int64
+	PostId       int64
+	ReplacedDate time.Time // when this version was replaced
+	GUID         string
+	URL          string
+	Date         time.Time
+	Title        string
+	Author       string
+	Content      string
+}
+
+type PostRevisionList []*PostRevision
+
+// SaveRevision stores the current version of the post, as found in the
+// database, as a revision.
+func (p *Post) SaveRevision(tx *sql.Tx, now time.Time) error {
+	_, err := tx.Exec(
+		`INSERT INTO post_revisions (post, replaced_date, guid, url,
+		                             date, title, author, content)
+		   SELECT id, ?, guid, url, date, title, author, content
+		     FROM posts
+		     WHERE id = ?`,
+		Timestamp(now), p.Id)
+	if err != nil {
+		return fmt.Errorf("cannot save post revision: %v", err)
+	}
+
+	return nil
+}
+
+func (p *Post) DeleteRevisions(tx *sql.Tx) error {
+	_, err := tx.Exec(`DELETE FROM post_revisions WHERE post = ?`, p.Id)
+	if err != nil {
+		return fmt.Errorf("cannot delete post revisions: %v", err)
+	}
+
+	return nil
+}
+
+func (r *PostRevision) ReadFromRow(row *sql.Rows) error {
+	var replacedDate, date int64
+
+	err := row.Scan(&r.Id, &r.PostId, &replacedDate, &r.GUID, &r.URL,
+		&date, &r.Title, &r.Author, &r.Content)
+	if err != nil {
+		return err
+	}
+
+	r.ReplacedDate = TimestampTime(replacedDate)
+	r.Date = TimestampTime(date)
+
+	return nil
+}
+
+// LoadByPost loads the revisions of a post, oldest first.
+func (rl *PostRevisionList) LoadByPost(tx *sql.Tx, postId int64) error {
+	rows, err := tx.Query(
+		`SELECT id, post, replaced_date, guid, url, date, title, author,
+		        content
+		   FROM post_revisions
+		   WHERE post = ?
+		   ORDER BY replaced_date, id`, postId)
+	if err != nil {
+		return fmt.Errorf("cannot load post revisions: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		r := &PostRevision{}
+		if err := r.ReadFromRow(rows); err != nil {
+			return fmt.Errorf("invalid post revision: %v", err)
+		}
+
+		*rl = append(*rl, r)
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("cannot load post revisions: %v", err)
+	}
+
+	return nil
+}
+
+// NewPostRevision returns the current version of a post as a revision, so
+// that it can be compared to previous ones.
+func NewPostRevision(p *Post) *PostRevision {
+	return &PostRevision{
+		PostId:  p.Id,
+		GUID:    p.GUID,
+		URL:     p.URL,
+		Date:    p.Date,
+		Title:   p.Title,
+		Author:  p.Author,
+		Content: p.Content,
+	}
+}
+
+// Diff returns a textual description of the changes between the revision
+// and a more recent one.
+func (r *PostRevision) Diff(next *PostRevision) string {
+	var fields []DiffLine
+
+	addField := func(name, prev, cur string) {
+		if prev == cur {
+			return
+		}
+
+		fields = append(fields,
+			DiffLine{DiffDelete, name + ": " + prev},
+			DiffLine{DiffInsert, name + ": " + cur})
+	}
+
+	addField("guid", r.GUID, next.GUID)
+	addField("url", r.URL, next.URL)
+	addField("date", r.Date.Format(time.RFC3339),
+		next.Date.Format(time.RFC3339))
+	addField("title", r.Title, next.Title)
+	addField("author", r.Author, next.Author)
+
+	s := FormatDiff(fields, 0)
+
+	if r.Content != next.Content {
+		lines := DiffLines(HTMLTextLines(r.Content),
+			HTMLTextLines(next.Content))
+
+		if content := FormatDiff(lines, 2); content != "" {
+			s += content
+		} else {
+			s += "  (markup changes only)\n"
+		}
+	}
+
+	return s
+}
@@ -0,0 +1,162 @@
+// Copyright (c) 2016 Nicolas Martyanoff <khaelin@gmail.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"html"
+	"html/template"
+	"strings"
+)
+
+// Matching terms in search snippets are delimited by these markers, so that
+// each user of the search can highlight them as it sees fit.
+const (
+	SearchMatchStart = "\x02"
+	SearchMatchEnd   = "\x03"
+)
+
+type SearchResult struct {
+	Post    *Post
+	Rank    float64 // lower is better
+	Snippet string
+}
+
+type SearchResultList []*SearchResult
+
+// SearchQuery converts text typed by a user to a FTS5 query matching posts
+// containing all its terms. Each term is quoted so that characters such as
+// "/", "-" or "+", common in queries about Go, are not interpreted as FTS5
+// operators. Text without any term is rejected.
+func SearchQuery(text string) (string, error) {
+	terms := strings.Fields(text)
+	if len(terms) == 0 {
+		return "", fmt.Errorf("empty search query")
+	}
+
+	for i, term := range terms {
+		terms[i] = `"` + strings.Replace(term, `"`, `""`, -1) + `"`
+	}
+
+	return strings.Join(terms, " "), nil
+}
+
+// Search loads the posts matching a full-text query, best matches first.
+// The query uses the SQLite FTS5 syntax; use SearchQuery for user input.
+func (rl *SearchResultList) Search(tx *sql.Tx, query string, count int, offset int) error {
+	// The title is more significant than the author, which is more
+	// significant than the content.
+	rows, err := tx.Query(
+		`SELECT `+postColumns+`,
+		        bm25(posts_fts, 10.0, 5.0, 1.0) AS rank,
+		        snippet(posts_fts, 2, ?, ?, '…', 24)
+		   FROM posts_fts
+		   INNER JOIN posts AS p ON p.id = posts_fts.rowid
+		   INNER JOIN feeds AS f ON f.id = p.feed
+		   WHERE posts_fts MATCH ? AND f.enabled = 1 AND p.enabled = 1
+		   ORDER BY rank
+		   LIMIT ? OFFSET ?`,
+		SearchMatchStart, SearchMatchEnd, query, count, offset)
+	if err != nil {
+		return fmt.Errorf("cannot search posts: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		p := &Post{}
+		r := &SearchResult{Post: p}
+
+		if err := p.ReadFromRow(rows, &r.Rank, &r.Snippet); err != nil {
+			return fmt.Errorf("invalid search result: %v", err)
+		}
+
+		*rl = append(*rl, r)
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("cannot search posts: %v", err)
+	}
+
+	return nil
+}
+
+// HighlightedSnippet returns the snippet of the result with matching terms
+// delimited by the start and end strings.
+func (r *SearchResult) HighlightedSnippet(start, end string) string {
+	s := strings.Replace(r.Snippet, SearchMatchStart, start, -1)
+	return strings.Replace(s, SearchMatchEnd, end, -1)
+}
+
+// SnippetHTML returns the snippet of the result as HTML, with matching
+// terms in mark elements.
+func (r *SearchResult) SnippetHTML() template.HTML {
+	s := html.EscapeString(r.Snippet)
+	s = strings.Replace(s, SearchMatchStart, "<mark>", -1)
+	s = strings.Replace(s, SearchMatchEnd, "</mark>", -1)
+	return template.HTML(s)
+}
+
+// UpdateSearchIndex indexes the title, author and text content of the post.
+// When the post does not have an author, the author of its feed is indexed
+// instead.
+func (p *Post) UpdateSearchIndex(tx *sql.Tx) error {
+	if err := p.DeleteSearchIndex(tx); err != nil {
+		return err
+	}
+
+	_, err := tx.Exec(
+		`INSERT INTO posts_fts (rowid, title, author, content)
+		   VALUES (?, ?,
+		           coalesce(nullif(?, ''),
+		                    (SELECT author FROM feeds WHERE id = ?)),
+		           ?)`,
+		p.Id, p.Title, p.Author, p.FeedId, HTMLText(p.Content))
+	if err != nil {
+		return fmt.Errorf("cannot index post: %v", err)
+	}
+
+	return nil
+}
+
+func (p *Post) DeleteSearchIndex(tx *sql.Tx) error {
+	_, err := tx.Exec(`DELETE FROM posts_fts WHERE rowid = ?`, p.Id)
+	if err != nil {
+		return fmt.Errorf("cannot delete post from search index: %v",
+			err)
+	}
+
+	return nil
+}
+
+// RebuildSearchIndex indexes all posts from scratch.
+func RebuildSearchIndex(tx *sql.Tx) error {
+	if _, err := tx.Exec(`DELETE FROM posts_fts`); err != nil {
+		return fmt.Errorf("cannot clear search index: %v", err)
+	}
+
+	var posts PostList
+	if err := posts.LoadAll(tx); err != nil {
+		return err
+	}
+
+	for _, p := range posts {
+		if err := p.UpdateSearchIndex(tx); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
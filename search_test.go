@@ -0,0 +1,51 @@
+// Copyright (c) 2016 Nicolas Martyanoff <khaelin@gmail.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package main
+
+import "testing"
+
+func TestSearchQuery(t *testing.T) {
+	tests := []struct {
+		text  string
+		query string
+	}{
+		{"go", `"go"`},
+		{"  go   modules ", `"go" "modules"`},
+		{"net/http", `"net/http"`},
+		{"go-cmp c++ -v", `"go-cmp" "c++" "-v"`},
+		{"NOT AND OR", `"NOT" "AND" "OR"`},
+		{"title:go*", `"title:go*"`},
+		{`say "hi"`, `"say" """hi"""`},
+	}
+
+	for _, test := range tests {
+		query, err := SearchQuery(test.text)
+		if err != nil {
+			t.Errorf("%q: %v", test.text, err)
+			continue
+		}
+
+		if query != test.query {
+			t.Errorf("%q: got %q, expected %q",
+				test.text, query, test.query)
+		}
+	}
+
+	for _, text := range []string{"", " ", "\t\n"} {
+		if _, err := SearchQuery(text); err == nil {
+			t.Errorf("%q: empty query accepted", text)
+		}
+	}
+}
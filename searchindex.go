@@ -0,0 +1,139 @@
+// Copyright (c) 2016 Nicolas Martyanoff <khaelin@gmail.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+)
+
+const SearchIndexExcerptLength = 280
+
+// The search index is split in one shard per year, listed in a manifest, so
+// that no single file grows indefinitely. Keys are kept short to reduce the
+// size of the index.
+type SearchIndexEntry struct {
+	Id      int64  `json:"i"`
+	Title   string `json:"t"`
+	Author  string `json:"a"`
+	Feed    string `json:"f"`
+	Date    string `json:"d"`
+	URL     string `json:"u"`
+	Excerpt string `json:"x"`
+
+	WordCount      int `json:"w"`
+	ReadingMinutes int `json:"r"`
+}
+
+type SearchIndexShard struct {
+	Year  int    `json:"year"`
+	Path  string `json:"path"`
+	Count int    `json:"count"`
+}
+
+type SearchIndexManifest struct {
+	Shards []SearchIndexShard `json:"shards"`
+}
+
+func (g *Generator) GenerateSearchIndex(tx *sql.Tx, feeds map[int64]*Feed, dirPath string) error {
+	// Load posts
+	var posts PostList
+	if err := posts.LoadEnabled(tx); err != nil {
+		return err
+	}
+
+	// Group them by year
+	var years []int
+	entries := make(map[int][]SearchIndexEntry)
+
+	for _, post := range posts {
+		feed := feeds[post.FeedId]
+
+		date := post.OrderDate()
+
+		year := date.Year()
+		if _, found := entries[year]; !found {
+			years = append(years, year)
+		}
+
+		var excerpt string
+		if feed.Display != DisplayTitle {
+			text := HTMLText(post.Content)
+			excerpt = TruncateText(text, SearchIndexExcerptLength)
+		}
+
+		entries[year] = append(entries[year], SearchIndexEntry{
+			Id:      post.Id,
+			Title:   post.Title,
+			Author:  HTMLText(post.AuthorName(feed)),
+			Feed:    HTMLText(feed.Title),
+			Date:    date.Format("2006-01-02"),
+			URL:     post.URL,
+			Excerpt: excerpt,
+
+			WordCount:      post.WordCount,
+			ReadingMinutes: post.ReadingMinutes(),
+		})
+	}
+
+	// Write shards and the manifest
+	outputDirPath := path.Join(g.OutputDirPath, dirPath)
+	if err := os.MkdirAll(outputDirPath, 0755); err != nil {
+		return fmt.Errorf("cannot create directory %s: %v",
+			outputDirPath, err)
+	}
+
+	// Shards are listed most recent first
+	sort.Sort(sort.Reverse(sort.IntSlice(years)))
+
+	var manifest SearchIndexManifest
+
+	for _, year := range years {
+		shardPath := path.Join(dirPath, fmt.Sprintf("posts-%d.json", year))
+
+		if err := g.WriteJSONFile(shardPath, entries[year]); err != nil {
+			return err
+		}
+
+		manifest.Shards = append(manifest.Shards, SearchIndexShard{
+			Year:  year,
+			Path:  shardPath,
+			Count: len(entries[year]),
+		})
+	}
+
+	manifestPath := path.Join(dirPath, "index.json")
+	return g.WriteJSONFile(manifestPath, &manifest)
+}
+
+func (g *Generator) WriteJSONFile(filePath string, value interface{}) error {
+	filePath = path.Join(g.OutputDirPath, filePath)
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("cannot encode %s: %v", filePath, err)
+	}
+
+	if err := ioutil.WriteFile(filePath, data, 0644); err != nil {
+		return fmt.Errorf("cannot write %s: %v", filePath, err)
+	}
+
+	return nil
+}
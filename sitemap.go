@@ -0,0 +1,192 @@
+// Copyright (c) 2016 Nicolas Martyanoff <khaelin@gmail.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// The maximum number of URLs in a sitemap according to the sitemap
+// protocol. Larger sitemaps are split and listed in a sitemap index.
+const SitemapMaxURLs = 50000
+
+const sitemapNamespace = "http://www.sitemaps.org/schemas/sitemap/0.9"
+
+type SitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	URLs    []SitemapURL `xml:"url"`
+}
+
+type sitemapIndex struct {
+	XMLName  xml.Name     `xml:"sitemapindex"`
+	Xmlns    string       `xml:"xmlns,attr"`
+	Sitemaps []SitemapURL `xml:"sitemap"`
+}
+
+// URL returns the absolute URL of a file of the website. The base URL can
+// contain a path if the website is not served at the root of its domain.
+func (g *Generator) URL(pagePath string) string {
+	return JoinURL(g.BaseURL, pagePath)
+}
+
+// BasePath returns the path of the root of the website on its server, e.g.
+// "/planet/" for "https://example.com/planet".
+func (g *Generator) BasePath() string {
+	u, err := url.Parse(g.BaseURL)
+	if err != nil {
+		return "/"
+	}
+
+	basePath := strings.Trim(u.Path, "/")
+	if basePath == "" {
+		return "/"
+	}
+
+	return "/" + basePath + "/"
+}
+
+// AddSitemapURL adds a page to the sitemap. The last modification date is
+// optional.
+func (g *Generator) AddSitemapURL(pagePath string, lastMod time.Time) {
+	u := SitemapURL{Loc: g.URL(pagePath)}
+	if !lastMod.IsZero() {
+		u.LastMod = lastMod.UTC().Format(time.RFC3339)
+	}
+
+	g.sitemap = append(g.sitemap, u)
+}
+
+// GenerateSitemap writes the pages added with AddSitemapURL to sitemap.xml,
+// or to several sitemaps listed in sitemap.xml if there are too many pages.
+func (g *Generator) GenerateSitemap() error {
+	if len(g.sitemap) <= SitemapMaxURLs {
+		return g.writeSitemapFile("sitemap.xml", &sitemapURLSet{
+			Xmlns: sitemapNamespace,
+			URLs:  g.sitemap,
+		})
+	}
+
+	index := &sitemapIndex{Xmlns: sitemapNamespace}
+
+	for i := 0; i*SitemapMaxURLs < len(g.sitemap); i++ {
+		start := i * SitemapMaxURLs
+		end := start + SitemapMaxURLs
+		if end > len(g.sitemap) {
+			end = len(g.sitemap)
+		}
+
+		urls := g.sitemap[start:end]
+
+		name := fmt.Sprintf("sitemap-%d.xml", i+1)
+		err := g.writeSitemapFile(name, &sitemapURLSet{
+			Xmlns: sitemapNamespace,
+			URLs:  urls,
+		})
+		if err != nil {
+			return err
+		}
+
+		// The last modification of a sitemap is the most recent of
+		// its pages; RFC 3339 dates in UTC can be compared as
+		// strings.
+		var lastMod string
+		for _, u := range urls {
+			if u.LastMod > lastMod {
+				lastMod = u.LastMod
+			}
+		}
+
+		index.Sitemaps = append(index.Sitemaps, SitemapURL{
+			Loc:     g.URL(name),
+			LastMod: lastMod,
+		})
+	}
+
+	return g.writeSitemapFile("sitemap.xml", index)
+}
+
+func (g *Generator) writeSitemapFile(filePath string, data interface{}) error {
+	content, err := xml.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return fmt.Errorf("cannot encode sitemap: %v", err)
+	}
+
+	content = append([]byte(xml.Header), content...)
+
+	filePath = path.Join(g.OutputDirPath, filePath)
+	if err := ioutil.WriteFile(filePath, content, 0644); err != nil {
+		return fmt.Errorf("cannot write %s: %v", filePath, err)
+	}
+
+	return nil
+}
+
+// GenerateRobots writes robots.txt, which allows crawlers to access the
+// whole website except the disallowed paths, and references the sitemap.
+// Crawlers only look for it at the root of a domain: when the website is
+// served from a path, its content has to be merged into the robots.txt file
+// of the domain.
+func (g *Generator) GenerateRobots() error {
+	var buf strings.Builder
+
+	buf.WriteString("User-agent: *\n")
+
+	if len(g.RobotsDisallow) == 0 {
+		buf.WriteString("Disallow:\n")
+	}
+
+	for _, p := range g.RobotsDisallow {
+		buf.WriteString("Disallow: " + g.BasePath() +
+			strings.TrimLeft(p, "/") + "\n")
+	}
+
+	buf.WriteString("\nSitemap: " + g.URL("sitemap.xml") + "\n")
+
+	filePath := path.Join(g.OutputDirPath, "robots.txt")
+	if err := ioutil.WriteFile(filePath, []byte(buf.String()), 0644); err != nil {
+		return fmt.Errorf("cannot write %s: %v", filePath, err)
+	}
+
+	return nil
+}
+
+// LastModified returns the most recent publication or modification date of
+// the posts of the list.
+func (pl PostList) LastModified() time.Time {
+	var date time.Time
+
+	for _, p := range pl {
+		if d := p.OrderDate(); d.After(date) {
+			date = d
+		}
+		if p.LastChanged.After(date) {
+			date = p.LastChanged
+		}
+	}
+
+	return date
+}
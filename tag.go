@@ -0,0 +1,301 @@
+// Copyright (c) 2016 Nicolas Martyanoff <khaelin@gmail.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// TagAliases maps tags to the tag they are an alias of, e.g. "golang" to
+// "go".
+type TagAliases map[string]string
+
+type TagCount struct {
+	Tag   string
+	Count int
+}
+
+type TagCountList []*TagCount
+
+// NormalizeTag converts a feed category to a tag which can be used in file
+// names: lower case letters and digits, other characters being replaced by
+// dashes. It returns an empty string if nothing is left.
+func NormalizeTag(s string) string {
+	var buf []rune
+
+	dash := false
+	for _, c := range strings.ToLower(strings.TrimSpace(s)) {
+		switch {
+		case c == '+':
+			// "c++" is not "c"
+			c = 'p'
+
+		case c == '#':
+			// "c#" is not "c"
+			c = 's'
+
+		case !unicode.IsLetter(c) && !unicode.IsDigit(c):
+			dash = true
+			continue
+		}
+
+		if dash && len(buf) > 0 {
+			buf = append(buf, '-')
+		}
+		dash = false
+		buf = append(buf, c)
+	}
+
+	return string(buf)
+}
+
+// NormalizeTags returns the sorted list of the normalized tags of a list of
+// categories, with aliases resolved.
+func NormalizeTags(categories []string, aliases TagAliases) []string {
+	set := make(map[string]bool)
+
+	for _, category := range categories {
+		tag := NormalizeTag(category)
+		if tag == "" {
+			continue
+		}
+
+		if alias, found := aliases[tag]; found {
+			tag = alias
+		}
+
+		set[tag] = true
+	}
+
+	tags := make([]string, 0, len(set))
+	for tag := range set {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+
+	return tags
+}
+
+func EqualTags(tags1, tags2 []string) bool {
+	if len(tags1) != len(tags2) {
+		return false
+	}
+
+	for i := range tags1 {
+		if tags1[i] != tags2[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// StoreTags replaces the tags of the post in the database.
+func (p *Post) StoreTags(tx *sql.Tx) error {
+	if err := p.DeleteTags(tx); err != nil {
+		return err
+	}
+
+	for _, tag := range p.Tags {
+		_, err := tx.Exec(
+			`INSERT INTO post_tags (post, tag) VALUES (?, ?)`,
+			p.Id, tag)
+		if err != nil {
+			return fmt.Errorf("cannot insert post tag: %v", err)
+		}
+	}
+
+	return nil
+}
+
+func (p *Post) DeleteTags(tx *sql.Tx) error {
+	_, err := tx.Exec(`DELETE FROM post_tags WHERE post = ?`, p.Id)
+	if err != nil {
+		return fmt.Errorf("cannot delete post tags: %v", err)
+	}
+
+	return nil
+}
+
+// LoadTags loads the tags of all posts of the list.
+func (pl PostList) LoadTags(tx *sql.Tx) error {
+	if len(pl) == 0 {
+		return nil
+	}
+
+	posts := make(map[int64]*Post)
+	ids := make([]string, len(pl))
+
+	for i, p := range pl {
+		p.Tags = nil
+		posts[p.Id] = p
+		ids[i] = fmt.Sprintf("%d", p.Id)
+	}
+
+	rows, err := tx.Query(
+		`SELECT post, tag
+		   FROM post_tags
+		   WHERE post IN (` + strings.Join(ids, ", ") + `)
+		   ORDER BY post, tag`)
+	if err != nil {
+		return fmt.Errorf("cannot load post tags: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		var tag string
+
+		if err := rows.Scan(&id, &tag); err != nil {
+			return fmt.Errorf("invalid post tag: %v", err)
+		}
+
+		if p, found := posts[id]; found {
+			p.Tags = append(p.Tags, tag)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("cannot load post tags: %v", err)
+	}
+
+	return nil
+}
+
+// LoadRangeByTag loads count displayed posts with a tag, most recent first,
+// skipping the first offset posts.
+func (pl *PostList) LoadRangeByTag(tx *sql.Tx, tag string, count, offset int) error {
+	return pl.Load(tx,
+		`SELECT `+postColumns+`
+		   FROM posts AS p
+		   INNER JOIN feeds AS f ON f.id = p.feed
+		   INNER JOIN post_tags AS t ON t.post = p.id
+		   WHERE f.enabled = 1 AND p.enabled = 1 AND t.tag = ?
+		   ORDER BY `+postOrderDate+` DESC
+		   LIMIT ? OFFSET ?`, tag, count, offset)
+}
+
+// LoadEnabled loads the tags of the displayed posts, sorted by name.
+func (tl *TagCountList) LoadEnabled(tx *sql.Tx) error {
+	rows, err := tx.Query(
+		`SELECT t.tag, count(*)
+		   FROM post_tags AS t
+		   INNER JOIN posts AS p ON p.id = t.post
+		   INNER JOIN feeds AS f ON f.id = p.feed
+		   WHERE f.enabled = 1 AND p.enabled = 1
+		   GROUP BY t.tag
+		   ORDER BY t.tag`)
+	if err != nil {
+		return fmt.Errorf("cannot load tags: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		tc := &TagCount{}
+		if err := rows.Scan(&tc.Tag, &tc.Count); err != nil {
+			return fmt.Errorf("invalid tag: %v", err)
+		}
+
+		*tl = append(*tl, tc)
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("cannot load tags: %v", err)
+	}
+
+	return nil
+}
+
+func (ta TagAliases) Load(tx *sql.Tx) error {
+	rows, err := tx.Query(`SELECT alias, tag FROM tag_aliases`)
+	if err != nil {
+		return fmt.Errorf("cannot load tag aliases: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var alias, tag string
+		if err := rows.Scan(&alias, &tag); err != nil {
+			return fmt.Errorf("invalid tag alias: %v", err)
+		}
+
+		ta[alias] = tag
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("cannot load tag aliases: %v", err)
+	}
+
+	return nil
+}
+
+// AddTagAlias records an alias and renames the existing tags of posts
+// accordingly. If tag is itself an alias, the alias refers to the tag it
+// resolves to, so that aliases never have to be resolved more than once;
+// this tag is returned.
+func AddTagAlias(tx *sql.Tx, alias, tag string) (string, error) {
+	seen := map[string]bool{alias: true}
+
+	for {
+		if seen[tag] {
+			return "", fmt.Errorf("tag %s cannot be an alias of "+
+				"itself", alias)
+		}
+		seen[tag] = true
+
+		var target string
+		row := tx.QueryRow(
+			`SELECT tag FROM tag_aliases WHERE alias = ?`, tag)
+		if err := row.Scan(&target); err == sql.ErrNoRows {
+			break
+		} else if err != nil {
+			return "", fmt.Errorf("cannot load tag alias: %v", err)
+		}
+
+		tag = target
+	}
+
+	_, err := tx.Exec(
+		`INSERT OR REPLACE INTO tag_aliases (alias, tag) VALUES (?, ?)`,
+		alias, tag)
+	if err != nil {
+		return "", fmt.Errorf("cannot insert tag alias: %v", err)
+	}
+
+	// Aliases of the alias now point to the new tag
+	_, err = tx.Exec(
+		`UPDATE tag_aliases SET tag = ? WHERE tag = ?`, tag, alias)
+	if err != nil {
+		return "", fmt.Errorf("cannot update tag aliases: %v", err)
+	}
+
+	_, err = tx.Exec(
+		`INSERT OR IGNORE INTO post_tags (post, tag)
+		   SELECT post, ? FROM post_tags WHERE tag = ?`, tag, alias)
+	if err != nil {
+		return "", fmt.Errorf("cannot rename post tags: %v", err)
+	}
+
+	_, err = tx.Exec(`DELETE FROM post_tags WHERE tag = ?`, alias)
+	if err != nil {
+		return "", fmt.Errorf("cannot rename post tags: %v", err)
+	}
+
+	return tag, nil
+}
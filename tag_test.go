@@ -0,0 +1,70 @@
+// Copyright (c) 2016 Nicolas Martyanoff <khaelin@gmail.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package main
+
+import "testing"
+
+func TestNormalizeTag(t *testing.T) {
+	tests := []struct {
+		category string
+		tag      string
+	}{
+		{"go", "go"},
+		{"Go", "go"},
+		{"  Go  ", "go"},
+		{"Go Modules", "go-modules"},
+		{"go  --  modules", "go-modules"},
+		{"web/http", "web-http"},
+		{"-go-", "go"},
+		{"Go 1.21", "go-1-21"},
+		{"Développement", "développement"},
+		{"c++", "cpp"},
+		{"C#", "cs"},
+		{"go +1", "go-p1"},
+		{"f #", "f-s"},
+		{"", ""},
+		{"  ", ""},
+		{"!?", ""},
+	}
+
+	for _, test := range tests {
+		tag := NormalizeTag(test.category)
+		if tag != test.tag {
+			t.Errorf("%q: got %q, expected %q",
+				test.category, tag, test.tag)
+		}
+	}
+}
+
+func TestNormalizeTags(t *testing.T) {
+	aliases := TagAliases{"golang": "go"}
+
+	tests := []struct {
+		categories []string
+		tags       []string
+	}{
+		{nil, []string{}},
+		{[]string{"Go", "golang", "GO"}, []string{"go"}},
+		{[]string{"web", "Golang", "", "!"}, []string{"go", "web"}},
+	}
+
+	for _, test := range tests {
+		tags := NormalizeTags(test.categories, aliases)
+		if !EqualTags(tags, test.tags) {
+			t.Errorf("%q: got %q, expected %q",
+				test.categories, tags, test.tags)
+		}
+	}
+}
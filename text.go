@@ -0,0 +1,148 @@
+// Copyright (c) 2016 Nicolas Martyanoff <khaelin@gmail.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+)
+
+// HTMLText returns the text content of an HTML fragment. Whitespace is
+// collapsed, and the content of script and style elements is ignored.
+func HTMLText(s string) string {
+	return htmlText(s, ' ', false)
+}
+
+// HTMLProseText returns the text content of an HTML fragment like HTMLText,
+// ignoring code blocks.
+func HTMLProseText(s string) string {
+	return htmlText(s, ' ', true)
+}
+
+// HTMLTextLines returns the text content of an HTML fragment with one line
+// per block element, for example a paragraph or a list item.
+func HTMLTextLines(s string) []string {
+	text := htmlText(s, '\n', false)
+	if text == "" {
+		return nil
+	}
+
+	return strings.Split(text, "\n")
+}
+
+func htmlText(s string, blockSep byte, skipCode bool) string {
+	var buf bytes.Buffer
+
+	var sep byte
+	skip := 0
+
+	addSep := func(c byte) {
+		if sep != blockSep {
+			sep = c
+		}
+	}
+
+	z := html.NewTokenizer(strings.NewReader(s))
+
+	for {
+		tt := z.Next()
+
+		switch tt {
+		case html.ErrorToken:
+			// Either the end of the fragment or invalid HTML; in
+			// both cases, we keep what we have.
+			return strings.TrimSpace(buf.String())
+
+		case html.StartTagToken, html.EndTagToken:
+			name, _ := z.TagName()
+			tag := string(name)
+
+			if tag == "script" || tag == "style" ||
+				(skipCode && tag == "pre") {
+				if tt == html.StartTagToken {
+					skip++
+				} else if skip > 0 {
+					skip--
+				}
+			}
+
+			if isBlockElement(tag) {
+				addSep(blockSep)
+			}
+
+		case html.SelfClosingTagToken:
+			name, _ := z.TagName()
+			if isBlockElement(string(name)) {
+				addSep(blockSep)
+			}
+
+		case html.TextToken:
+			if skip > 0 {
+				continue
+			}
+
+			for _, c := range string(z.Text()) {
+				if unicode.IsSpace(c) {
+					addSep(' ')
+					continue
+				}
+
+				if sep != 0 && buf.Len() > 0 {
+					buf.WriteByte(sep)
+				}
+				sep = 0
+
+				buf.WriteRune(c)
+			}
+		}
+	}
+}
+
+func isBlockElement(tag string) bool {
+	switch tag {
+	case "address", "article", "aside", "blockquote", "br", "dd", "div",
+		"dl", "dt", "figcaption", "figure", "footer", "h1", "h2", "h3",
+		"h4", "h5", "h6", "header", "hr", "li", "main", "nav", "ol",
+		"p", "pre", "section", "table", "td", "th", "tr", "ul":
+		return true
+	}
+
+	return false
+}
+
+// TruncateText returns at most maxLen characters of a text, cutting at a word
+// boundary when possible and appending an ellipsis when the text was
+// truncated.
+func TruncateText(s string, maxLen int) string {
+	if utf8.RuneCountInString(s) <= maxLen {
+		return s
+	}
+
+	runes := []rune(s)
+	runes = runes[:maxLen]
+
+	for i := len(runes) - 1; i > maxLen/2; i-- {
+		if unicode.IsSpace(runes[i]) {
+			runes = runes[:i]
+			break
+		}
+	}
+
+	return strings.TrimRightFunc(string(runes), unicode.IsSpace) + "…"
+}
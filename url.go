@@ -0,0 +1,126 @@
+// Copyright (c) 2016 Nicolas Martyanoff <khaelin@gmail.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package main
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Query parameters used to track visitors, which do not change the resource
+// designated by the URL. Generic names such as "ref" are not included since
+// some sites use them to designate content.
+var trackingQueryParameters = map[string]bool{
+	"fbclid":  true,
+	"gclid":   true,
+	"mc_cid":  true,
+	"mc_eid":  true,
+	"yclid":   true,
+	"_hsenc":  true,
+	"_hsmi":   true,
+	"igshid":  true,
+	"msclkid": true,
+}
+
+// CanonicalURL normalizes a URL so that different URLs designating the same
+// post are equal: the scheme is always https, the host is in lower case
+// without "www." prefix or default port, the trailing slash of the path, the
+// fragment and tracking query parameters are removed, and the remaining
+// query parameters are sorted. Invalid URLs are returned unmodified.
+func CanonicalURL(s string) string {
+	u, err := url.Parse(strings.TrimSpace(s))
+	if err != nil || u.Host == "" {
+		return s
+	}
+
+	u.Scheme = "https"
+	u.User = nil
+	u.Fragment = ""
+
+	host := strings.ToLower(u.Host)
+	if h, port := splitHostPort(host); port == "80" || port == "443" {
+		host = h
+	}
+	u.Host = strings.TrimPrefix(host, "www.")
+
+	u.Path = strings.TrimRight(u.Path, "/")
+	u.RawPath = ""
+
+	query := u.Query()
+	for name := range query {
+		lname := strings.ToLower(name)
+		if strings.HasPrefix(lname, "utm_") ||
+			trackingQueryParameters[lname] {
+			query.Del(name)
+		}
+	}
+	u.RawQuery = query.Encode() // sorted by key
+
+	return u.String()
+}
+
+func splitHostPort(host string) (string, string) {
+	i := strings.LastIndexByte(host, ':')
+	if i == -1 || strings.IndexByte(host[i:], ']') != -1 {
+		return host, ""
+	}
+
+	return host[:i], host[i+1:]
+}
+
+// URLHost returns the host of a canonical URL (see CanonicalURL), or an
+// empty string if the URL is invalid.
+func URLHost(s string) string {
+	u, err := url.Parse(CanonicalURL(s))
+	if err != nil {
+		return ""
+	}
+
+	return u.Host
+}
+
+// Slug returns a string which can be used in URLs and file names to identify
+// a title: ASCII letters in lower case and digits, words being separated by
+// dashes. It is cut at a word boundary if it is longer than maxLen
+// characters.
+func Slug(title string, maxLen int) string {
+	fields := strings.FieldsFunc(strings.ToLower(title), func(c rune) bool {
+		return !(c >= 'a' && c <= 'z') && !(c >= '0' && c <= '9')
+	})
+
+	slug := ""
+	for _, field := range fields {
+		if len(slug)+len(field)+1 > maxLen {
+			if slug == "" {
+				slug = field[:maxLen]
+			}
+			break
+		}
+
+		if slug != "" {
+			slug += "-"
+		}
+		slug += field
+	}
+
+	return slug
+}
+
+// JoinURL returns the absolute URL of a path relative to a base URL, e.g. the
+// URL of a page of the website.
+func JoinURL(baseURL, filePath string) string {
+	return strings.TrimRight(baseURL, "/") + "/" +
+		strings.TrimLeft(filePath, "/")
+}
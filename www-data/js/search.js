@@ -0,0 +1,213 @@
+// Client-side search over the static index generated with the website.
+//
+// The index is made of a manifest (search/index.json) listing one shard per
+// year; each shard is an array of posts with the following short keys:
+//
+//   i: id, t: title, a: author, f: feed, d: date, u: url, x: excerpt
+
+(function () {
+  "use strict";
+
+  var maxResults = 50;
+
+  var form = document.getElementById("search-form");
+  var input = document.getElementById("search-query");
+  var status = document.getElementById("search-status");
+  var results = document.getElementById("search-results");
+
+  var posts = null;
+  var loading = null;
+
+  function fetchJSON(url) {
+    return new Promise(function (resolve, reject) {
+      var xhr = new XMLHttpRequest();
+      xhr.open("GET", url);
+      xhr.onload = function () {
+        if (xhr.status < 200 || xhr.status >= 300) {
+          reject(new Error("cannot fetch " + url + ": status " + xhr.status));
+          return;
+        }
+
+        try {
+          resolve(JSON.parse(xhr.responseText));
+        } catch (e) {
+          reject(e);
+        }
+      };
+      xhr.onerror = function () {
+        reject(new Error("cannot fetch " + url));
+      };
+      xhr.send();
+    });
+  }
+
+  function loadIndex() {
+    if (loading) {
+      return loading;
+    }
+
+    loading = fetchJSON("search/index.json").then(function (manifest) {
+      var shards = manifest.shards.map(function (shard) {
+        return fetchJSON(shard.path);
+      });
+
+      return Promise.all(shards);
+    }).then(function (shards) {
+      posts = [];
+      shards.forEach(function (shard) {
+        shard.forEach(function (post) {
+          post.title = post.t.toLowerCase();
+          post.meta = (post.a + " " + post.f).toLowerCase();
+          post.text = post.x.toLowerCase();
+          posts.push(post);
+        });
+      });
+    });
+
+    return loading;
+  }
+
+  function tokenize(query) {
+    return query.toLowerCase().split(/\s+/).filter(function (term) {
+      return term.length > 0;
+    });
+  }
+
+  // Every term must match; matches in the title are worth more than matches
+  // in the author or feed name, which are worth more than matches in the
+  // excerpt.
+  function score(post, terms) {
+    var total = 0;
+
+    for (var i = 0; i < terms.length; i++) {
+      var term = terms[i];
+      var s = 0;
+
+      if (post.title.indexOf(term) >= 0) {
+        s += 10;
+      }
+      if (post.meta.indexOf(term) >= 0) {
+        s += 5;
+      }
+      if (post.text.indexOf(term) >= 0) {
+        s += 1;
+      }
+
+      if (s == 0) {
+        return 0;
+      }
+
+      total += s;
+    }
+
+    return total;
+  }
+
+  function search(query) {
+    var terms = tokenize(query);
+    if (terms.length == 0) {
+      return [];
+    }
+
+    var matches = [];
+    posts.forEach(function (post) {
+      var s = score(post, terms);
+      if (s > 0) {
+        matches.push({post: post, score: s});
+      }
+    });
+
+    matches.sort(function (a, b) {
+      if (a.score != b.score) {
+        return b.score - a.score;
+      }
+
+      return a.post.d < b.post.d ? 1 : (a.post.d > b.post.d ? -1 : 0);
+    });
+
+    return matches;
+  }
+
+  function element(name, className, text) {
+    var e = document.createElement(name);
+    if (className) {
+      e.className = className;
+    }
+    if (text) {
+      e.textContent = text;
+    }
+    return e;
+  }
+
+  function render(query, matches) {
+    while (results.firstChild) {
+      results.removeChild(results.firstChild);
+    }
+
+    if (query.trim() == "") {
+      status.textContent = "";
+      return;
+    }
+
+    if (matches.length == 0) {
+      status.textContent = "No post found.";
+      return;
+    } else if (matches.length > maxResults) {
+      status.textContent = matches.length + " posts found, showing the " +
+        maxResults + " best matches.";
+    } else {
+      status.textContent = matches.length +
+        (matches.length == 1 ? " post found." : " posts found.");
+    }
+
+    matches.slice(0, maxResults).forEach(function (match) {
+      var post = match.post;
+
+      var li = element("li", "search-result");
+
+      var title = element("a", "title", post.t);
+      title.href = post.u;
+
+      var h = element("h2");
+      h.appendChild(title);
+      li.appendChild(h);
+
+      li.appendChild(element("div", "meta",
+                             post.a + " — " + post.d + " — " +
+                             post.r + " min read"));
+      li.appendChild(element("p", "excerpt", post.x));
+
+      results.appendChild(li);
+    });
+  }
+
+  function update() {
+    var query = input.value;
+
+    if (posts) {
+      render(query, search(query));
+      return;
+    }
+
+    status.textContent = "Loading the search index…";
+    loadIndex().then(function () {
+      render(input.value, search(input.value));
+    }, function (err) {
+      status.textContent = "Cannot load the search index: " + err.message;
+      loading = null;
+    });
+  }
+
+  form.addEventListener("submit", function (event) {
+    event.preventDefault();
+    update();
+  });
+
+  input.addEventListener("input", update);
+
+  var params = /[?&]q=([^&]*)/.exec(window.location.search);
+  if (params) {
+    input.value = decodeURIComponent(params[1].replace(/\+/g, " "));
+    update();
+  }
+})();